@@ -0,0 +1,261 @@
+// Package cache handles the files in which graphs are persisted.
+// It takes care of writing them atomically, keeping a few backups around and
+// recovering from corrupt files, without knowing anything about their content.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lzambarda/hbt/internal"
+)
+
+// BackupPath returns the path of the n-th most recent backup of filePath,
+// starting from 1.
+func BackupPath(filePath string, n int) string {
+	return fmt.Sprintf("%s.%d", filePath, n)
+}
+
+// Write atomically replaces the content of filePath with data. The previous
+// content is kept as the most recent backup, and at most internal.Backups
+// backups are retained.
+func Write(filePath string, data []byte) error {
+	return write(filePath, data, rotate)
+}
+
+// WriteAtomic replaces the content of filePath with data, without keeping any
+// backup. Readers never see a partially written file.
+func WriteAtomic(filePath string, data []byte) error {
+	return write(filePath, data, nil)
+}
+
+// write writes data to a temporary file next to filePath and then renames it
+// over filePath, calling before right before the rename if not nil.
+func write(filePath string, data []byte, before func(filePath string) error) error {
+	data, err := encrypt(data)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // Gone after a successful rename.
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // Already failing.
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // Already failing.
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if before != nil {
+		if err = before(filePath); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// rotate shifts every backup of filePath by one, dropping the oldest one, and
+// links filePath itself to the first backup slot. filePath is left in place,
+// so that it exists at all times, until write renames the new content over
+// it.
+func rotate(filePath string) error {
+	if internal.Backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for n := internal.Backups - 1; n > 0; n-- {
+		err := os.Rename(BackupPath(filePath, n), BackupPath(filePath, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// Still there if a single backup is kept.
+	if err := os.Remove(BackupPath(filePath, 1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(filePath, BackupPath(filePath, 1))
+}
+
+// ReadFile returns the content of filePath, decrypting it if needed.
+func ReadFile(filePath string) ([]byte, error) {
+	b, err := os.ReadFile(filePath) //nolint:gosec // It is okay.
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(b)
+}
+
+// Read reads filePath and hands its content to decode. If decode fails the
+// file is considered corrupt: it is moved aside with a timestamp and the
+// backups are tried from the most recent one. If no valid content can be
+// found decode is never successfully called, which callers should treat as
+// an empty cache.
+// A missing filePath is not an error, but its backups are read if there are
+// any, as it might have been lost.
+// An error is only returned when the files cannot be accessed at all, or
+// cannot be decrypted with the configured key.
+func Read(filePath string, decode func(b []byte) error) error {
+	err := readAndDecode(filePath, decode)
+	if err == nil {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		if _, bErr := os.Stat(BackupPath(filePath, 1)); bErr != nil {
+			// Nothing to load when missing
+			return nil
+		}
+		slog.Warn("Cache is missing, reading the backups", "path", filePath)
+		return readBackups(filePath, decode)
+	}
+	var corrupt *corruptError
+	if !errors.As(err, &corrupt) {
+		return err
+	}
+	moved, qErr := quarantine(filePath)
+	if qErr != nil {
+		return fmt.Errorf("%s is corrupt (%v) and cannot be moved aside: %w", filePath, err, qErr)
+	}
+	slog.Warn("Cache is corrupt, moved aside", "path", filePath, "error", err, "moved", moved)
+	return readBackups(filePath, decode)
+}
+
+// readBackups hands the content of the most recent valid backup of filePath
+// to decode, if any.
+func readBackups(filePath string, decode func(b []byte) error) error {
+	var corrupt *corruptError
+	for n := 1; n <= internal.Backups; n++ {
+		backup := BackupPath(filePath, n)
+		err := readAndDecode(backup, decode)
+		if err == nil {
+			slog.Info("Restored cache from backup", "backup", backup)
+			return nil
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if !errors.As(err, &corrupt) {
+			return err
+		}
+		slog.Warn("Backup is corrupt too", "backup", backup, "error", err)
+	}
+	slog.Warn("No valid backup found, starting with an empty cache", "path", filePath)
+	return nil
+}
+
+// corruptError marks the errors which should trigger the recovery in Read.
+type corruptError struct {
+	err error
+}
+
+func (e *corruptError) Error() string { return e.err.Error() }
+func (e *corruptError) Unwrap() error { return e.err }
+
+func readAndDecode(filePath string, decode func(b []byte) error) error {
+	b, err := ReadFile(filePath)
+	if errors.Is(err, ErrCorrupt) {
+		return &corruptError{err}
+	}
+	if err != nil {
+		return err
+	}
+	if err = decode(b); err != nil {
+		return &corruptError{err}
+	}
+	return nil
+}
+
+const quarantineInfix = ".corrupt-"
+
+// quarantine moves a corrupt file out of the way, so that it can be
+// inspected later and it is not overwritten by the next save.
+func quarantine(filePath string) (string, error) {
+	moved := filePath + quarantineInfix + time.Now().Format("20060102T150405")
+	return moved, os.Rename(filePath, moved)
+}
+
+// Quarantined returns the paths of the corrupt versions of filePath which
+// Read moved aside, oldest first.
+func Quarantined(filePath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(filePath) + quarantineInfix
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) {
+			paths = append(paths, filepath.Join(filepath.Dir(filePath), e.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// Move moves filePath along with its backups to newPath, which must not exist,
+// copying them if they are on different file systems.
+func Move(filePath, newPath string) error {
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("cannot move %s: %s: %w", filePath, newPath, os.ErrExist)
+	}
+	if err := moveFile(filePath, newPath); err != nil {
+		return err
+	}
+	for n := 1; ; n++ {
+		err := moveFile(BackupPath(filePath, n), BackupPath(newPath, n))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func moveFile(filePath, newPath string) error {
+	err := os.Rename(filePath, newPath)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	src, err := os.Open(filePath) //nolint:gosec // It is okay.
+	if err != nil {
+		return err
+	}
+	defer src.Close() //nolint:errcheck // Only read.
+	tmp, err := os.CreateTemp(filepath.Dir(newPath), filepath.Base(newPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // Gone after a successful rename.
+	if _, err = io.Copy(tmp, src); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // Already failing.
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // Already failing.
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), newPath); err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/lzambarda/hbt/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("Write", testCacheWrite)
+	t.Run("Read", testCacheRead)
+	t.Run("Encryption", testCacheEncryption)
+	t.Run("Move", testCacheMove)
+}
+
+func testCacheWrite(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "cache")
+	for _, content := range []string{"1", "2", "3", "4", "5"} {
+		require.NoError(t, Write(filePath, []byte(content)))
+	}
+	assertContent(t, filePath, "5")
+	assertContent(t, BackupPath(filePath, 1), "4")
+	assertContent(t, BackupPath(filePath, 2), "3")
+	assertContent(t, BackupPath(filePath, internal.DefaultBackups), "2")
+	assert.NoFileExists(t, BackupPath(filePath, internal.DefaultBackups+1))
+
+	// The cache always exists, a crash while saving cannot lose it.
+	var missing bool
+	before := func(filePath string) error {
+		err := rotate(filePath)
+		_, statErr := os.Stat(filePath)
+		missing = statErr != nil
+		return err
+	}
+	require.NoError(t, write(filePath, []byte("6"), before))
+	assert.False(t, missing)
+	assertContent(t, filePath, "6")
+	assertContent(t, BackupPath(filePath, 1), "5")
+
+	defer func(backups int) { internal.Backups = backups }(internal.Backups)
+	internal.Backups = 1
+	require.NoError(t, Write(filePath, []byte("7")))
+	assertContent(t, BackupPath(filePath, 1), "6")
+}
+
+func testCacheRead(t *testing.T) {
+	errBad := errors.New("bad")
+	decode := func(got *string) func(b []byte) error {
+		return func(b []byte) error {
+			if string(b) == "bad" {
+				return errBad
+			}
+			*got = string(b)
+			return nil
+		}
+	}
+	filePath := path.Join(t.TempDir(), "cache")
+
+	var got string
+	require.NoError(t, Read(filePath, decode(&got)), "missing file")
+	assert.Empty(t, got)
+
+	require.NoError(t, Write(filePath, []byte("good")))
+	require.NoError(t, Write(filePath, []byte("bad")))
+	require.NoError(t, Write(filePath, []byte("bad")))
+	require.NoError(t, Read(filePath, decode(&got)))
+	assert.Equal(t, "good", got, "most recent valid backup")
+	assert.NoFileExists(t, filePath)
+
+	// A cache lost between two renames is restored from its backups.
+	require.NoError(t, Write(filePath, []byte("lost")))
+	require.NoError(t, Write(filePath, []byte("new")))
+	require.NoError(t, os.Remove(filePath))
+	require.NoError(t, Read(filePath, decode(&got)))
+	assert.Equal(t, "lost", got)
+}
+
+func assertContent(t *testing.T, filePath, expected string) {
+	t.Helper()
+	b, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(b))
+}
+
+func testCacheEncryption(t *testing.T) {
+	defer SetKey("") //nolint:errcheck // Cannot fail.
+	filePath := path.Join(t.TempDir(), "cache")
+	var got string
+	decode := func(b []byte) error {
+		got = string(b)
+		return nil
+	}
+
+	// Plain files can still be read after enabling the encryption.
+	require.NoError(t, Write(filePath, []byte("plain")))
+	require.NoError(t, SetKey("correct horse battery staple"))
+	require.NoError(t, Read(filePath, decode))
+	assert.Equal(t, "plain", got)
+
+	require.NoError(t, Write(filePath, []byte("secret")))
+	b, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "secret")
+	require.NoError(t, Read(filePath, decode))
+	assert.Equal(t, "secret", got)
+
+	// A wrong or missing key is an error, the file must not be touched.
+	require.NoError(t, SetKey("6f0c0e4fd0a4ab9b5c5e2a4c0d1f7e3a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e"))
+	assert.ErrorIs(t, Read(filePath, decode), ErrWrongKey)
+	require.NoError(t, SetKey(""))
+	assert.ErrorIs(t, Read(filePath, decode), ErrNoKey)
+	assert.FileExists(t, filePath)
+
+	// Tampering is detected as corruption and the backup is used.
+	require.NoError(t, SetKey("correct horse battery staple"))
+	b[len(b)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(filePath, b, 0o600))
+	require.NoError(t, Read(filePath, decode))
+	assert.Equal(t, "plain", got)
+	assert.NoFileExists(t, filePath)
+}
+
+func testCacheMove(t *testing.T) {
+	dir := t.TempDir()
+	filePath := path.Join(dir, "cache")
+	for _, content := range []string{"1", "2", "3"} {
+		require.NoError(t, Write(filePath, []byte(content)))
+	}
+	newPath := path.Join(dir, "sub", "cache")
+	require.NoError(t, os.Mkdir(path.Dir(newPath), 0o700))
+	require.NoError(t, Move(filePath, newPath))
+	assertContent(t, newPath, "3")
+	assertContent(t, BackupPath(newPath, 1), "2")
+	assertContent(t, BackupPath(newPath, 2), "1")
+	assert.NoFileExists(t, filePath)
+	assert.NoFileExists(t, BackupPath(filePath, 1))
+
+	require.NoError(t, Write(filePath, []byte("4")))
+	assert.ErrorIs(t, Move(filePath, newPath), os.ErrExist)
+	assertContent(t, newPath, "3")
+	assert.ErrorIs(t, Move(path.Join(dir, "missing"), path.Join(dir, "other")), os.ErrNotExist)
+}
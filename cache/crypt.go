@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNoKey is returned when reading an encrypted file without a key.
+	ErrNoKey = errors.New("file is encrypted but no key is configured")
+	// ErrWrongKey is returned when reading a file encrypted with another key.
+	ErrWrongKey = errors.New("file is encrypted with a different key")
+	// ErrCorrupt is returned when an encrypted file fails authentication.
+	ErrCorrupt = errors.New("corrupt encrypted file")
+)
+
+// encryptedMagic prefixes every encrypted file. It is followed by a version
+// byte, the key id, the nonce and the sealed content.
+var encryptedMagic = []byte("HBTE")
+
+const (
+	encryptedVersion = 1
+	keyIDSize        = 4
+)
+
+var (
+	aead  cipher.AEAD
+	keyID []byte
+)
+
+// SetKey enables the encryption of every file written from now on, using
+// AES-256-GCM. The key material can either be 64 hexadecimal characters or
+// any other string, which is then hashed. In the latter case it should be long
+// and random, as no slow key derivation function is involved.
+// An empty key material disables the encryption.
+func SetKey(material string) error {
+	material = strings.TrimSpace(material)
+	if material == "" {
+		aead, keyID = nil, nil
+		return nil
+	}
+	key, err := hex.DecodeString(material)
+	if err != nil || len(key) != 32 {
+		sum := sha256.Sum256([]byte(material))
+		key = sum[:]
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	a, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	// The id lets us tell a wrong key apart from a corrupt file, without
+	// revealing anything about the key.
+	id := sha256.Sum256(append([]byte("hbt key id\x00"), key...))
+	aead, keyID = a, id[:keyIDSize]
+	return nil
+}
+
+// SetKeyFile is like SetKey, reading the key material from filePath.
+func SetKeyFile(filePath string) error {
+	b, err := os.ReadFile(filePath) //nolint:gosec // It is okay.
+	if err != nil {
+		return err
+	}
+	return SetKey(string(b))
+}
+
+// encrypt seals data if a key is set, otherwise it returns data as is.
+func encrypt(data []byte) ([]byte, error) {
+	if aead == nil {
+		return data, nil
+	}
+	header := make([]byte, 0, len(encryptedMagic)+1+keyIDSize+aead.NonceSize())
+	header = append(header, encryptedMagic...)
+	header = append(header, encryptedVersion)
+	header = append(header, keyID...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	// The header is authenticated too.
+	return aead.Seal(header, nonce, data, header), nil
+}
+
+// decrypt opens data if it is encrypted, otherwise it returns data as is, so
+// that unencrypted files keep working after enabling the encryption.
+func decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	if aead == nil {
+		return nil, ErrNoKey
+	}
+	headerSize := len(encryptedMagic) + 1 + keyIDSize + aead.NonceSize()
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	if v := data[len(encryptedMagic)]; v != encryptedVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCorrupt, v)
+	}
+	idStart := len(encryptedMagic) + 1
+	if !bytes.Equal(data[idStart:idStart+keyIDSize], keyID) {
+		return nil, ErrWrongKey
+	}
+	header := data[:headerSize]
+	nonce := header[idStart+keyIDSize:]
+	plain, err := aead.Open(nil, nonce, data[headerSize:], header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err) //nolint:errorlint // Only one %w.
+	}
+	return plain, nil
+}
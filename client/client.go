@@ -0,0 +1,55 @@
+// Package client talks to a running hbt server.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lzambarda/hbt/internal"
+	"github.com/lzambarda/hbt/server"
+)
+
+// Timeout bounds the whole exchange with the server.
+var Timeout = 5 * time.Second
+
+// ErrNoServer is returned when no server can be reached.
+var ErrNoServer = errors.New("no hbt server is reachable")
+
+// ServerError is the error reported by the server for a failed request.
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// Send sends the command args to the server listening on port and returns its
+// answer. If the server reports an error, it is returned as a ServerError.
+func Send(port string, args ...string) (string, error) {
+	c, err := net.DialTimeout("tcp4", internal.Host+":"+port, Timeout)
+	if err != nil {
+		return "", fmt.Errorf("%w on port %s: %v", ErrNoServer, port, err) //nolint:errorlint // Only one %w.
+	}
+	defer c.Close() //nolint:errcheck // It is okay.
+	if err = c.SetDeadline(time.Now().Add(Timeout)); err != nil {
+		return "", err
+	}
+	if _, err = c.Write(server.EncodeRequest(args)); err != nil {
+		return "", err
+	}
+	// The server reads until the end of the request.
+	if err = c.(*net.TCPConn).CloseWrite(); err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(c)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(string(b), server.ErrorPrefix) {
+		return "", ServerError(strings.TrimPrefix(string(b), server.ErrorPrefix))
+	}
+	return string(b), nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/lzambarda/hbt/graph/naive"
+	"github.com/lzambarda/hbt/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	cachePath := path.Join(t.TempDir(), "cache")
+	g := naive.NewGraph(10, 3)
+	s := server.New(g, cachePath)
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(l)
+	}()
+
+	_, err = Send(port, "track", "1", "/d", "make")
+	require.NoError(t, err)
+	result, err := Send(port, "hint", "1", "/d")
+	require.NoError(t, err)
+	assert.Equal(t, "make", result)
+
+	_, err = Send(port, "nope")
+	var serverErr ServerError
+	require.True(t, errors.As(err, &serverErr))
+	assert.Equal(t, `unknown command: "nope"`, serverErr.Error())
+
+	result, err = Send(port, "save-now")
+	require.NoError(t, err)
+	assert.Equal(t, "saved "+cachePath, result)
+	_, err = Send(port, "track", "1", "/d", "ls")
+	require.NoError(t, err)
+	result, err = Send(port, "reload", "cache")
+	require.NoError(t, err)
+	assert.Equal(t, "reloaded "+cachePath, result)
+	result, err = Send(port, "stats")
+	require.NoError(t, err)
+	assert.Contains(t, result, "commands: 1\n", "ls was not saved")
+
+	multiline := "for f in *; do\n\techo $f\ndone"
+	_, err = Send(port, "track", "2", "/multi\nline", multiline)
+	require.NoError(t, err)
+	result, err = Send(port, "hint", "2", "/multi\nline")
+	require.NoError(t, err)
+	assert.Equal(t, multiline, result, "newlines are kept")
+
+	result, err = Send(port, "shutdown")
+	require.NoError(t, err)
+	assert.Equal(t, "shutting down", result)
+	require.NoError(t, <-served)
+	saved := naive.NewGraph(10, 3)
+	require.NoError(t, saved.LoadStrict(cachePath))
+	assert.Equal(t, "make", saved.Hint("1", "/d"))
+
+	_, err = Send(port, "hint", "1", "/d")
+	assert.True(t, errors.Is(err, ErrNoServer))
+}
+
+func TestClientTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { Timeout = timeout }(Timeout)
+	Timeout = 50 * time.Millisecond
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close() //nolint:errcheck // It is okay.
+	// Accept the connection but never answer.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		<-done
+		c.Close() //nolint:errcheck,gosec // It is okay.
+	}()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	_, err = Send(port, "hint", "1", "/d")
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+}
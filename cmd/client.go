@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lzambarda/hbt/client"
+	"github.com/lzambarda/hbt/internal"
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes of the client command, so that shell integrations can tell
+// failures apart.
+const (
+	// ExitRequestFailed is used when the server reports an error.
+	ExitRequestFailed = 1
+	// ExitNoServer is used when no server is listening.
+	ExitNoServer = 2
+	// ExitTimeout is used when the server does not answer in time.
+	ExitTimeout = 3
+)
+
+// noSpawn lists the commands which are pointless without a running server.
+var noSpawn = map[string]bool{
+	"end":      true,
+	"shutdown": true,
+}
+
+// clientCommand sends a request to the running server, it is what the shell
+// integrations use to talk to it.
+var clientCommand = &cli.Command{
+	Name:      "client",
+	Usage:     "send a request to the running server and print its response (e.g. track, hint, end, del)",
+	ArgsUsage: "COMMAND [ARG...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "spawn",
+			Usage:       "start the server in the background if it is not running",
+			Value:       true,
+			Destination: &internal.AutoSpawn,
+			EnvVars:     []string{internal.AutoSpawnName},
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return cli.Exit(ErrNotEnoughArguments, ExitRequestFailed)
+		}
+		result, err := client.Send(internal.Port, c.Args().Slice()...)
+		if errors.Is(err, client.ErrNoServer) && internal.AutoSpawn && !noSpawn[c.Args().First()] {
+			if err = startDaemon(c); err != nil {
+				return cli.Exit(err, ExitNoServer)
+			}
+			result, err = client.Send(internal.Port, c.Args().Slice()...)
+		}
+		var netErr net.Error
+		switch {
+		case err == nil:
+		case errors.Is(err, client.ErrNoServer):
+			return cli.Exit(err, ExitNoServer)
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return cli.Exit(err, ExitTimeout)
+		default:
+			return cli.Exit(err, ExitRequestFailed)
+		}
+		if result != "" {
+			fmt.Println(result)
+		}
+		return nil
+	},
+}
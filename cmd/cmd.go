@@ -2,39 +2,124 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/lzambarda/hbt/cache"
+	"github.com/lzambarda/hbt/client"
+	"github.com/lzambarda/hbt/daemon"
+	"github.com/lzambarda/hbt/graph"
 	"github.com/lzambarda/hbt/graph/naive"
+	"github.com/lzambarda/hbt/ignore"
 	"github.com/lzambarda/hbt/internal"
+	"github.com/lzambarda/hbt/logging"
+	"github.com/lzambarda/hbt/redact"
 	"github.com/lzambarda/hbt/server"
+	"github.com/lzambarda/hbt/shell"
+	"github.com/lzambarda/hbt/syncdir"
+	"github.com/lzambarda/hbt/xdg"
 	"github.com/urfave/cli/v2"
 )
 
 var (
-	g         server.Graph
-	cachePath string
-	root      = &cli.App{
+	g              server.Graph
+	srv            *server.Server
+	cachePath      string
+	cacheLock      *daemon.Lock
+	redactRules    = cli.NewStringSlice()
+	ignoreCommands = cli.NewStringSlice()
+	ignoreDirs     = cli.NewStringSlice()
+	root           = &cli.App{
 		Name:        "hbt",
 		Usage:       "a zsh suggestion system",
 		Description: `Spawn a TCP server listening on the local port 43111 (can be changed with HBT_PORT).`,
 		Version:     internal.Version,
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "config",
+				Usage:       "configuration file, flags and environment variables take precedence over it",
+				DefaultText: "$XDG_CONFIG_HOME/hbt/config.toml",
+				Destination: &internal.ConfigPath,
+				EnvVars:     []string{internal.ConfigName},
+			},
 			&cli.BoolFlag{
 				Name:        "debug",
 				Aliases:     []string{"d"},
+				Usage:       "log at the debug level, whatever --log-level",
 				DefaultText: "false",
 				Destination: &internal.Debug,
 				EnvVars:     []string{internal.DebugName},
 			},
+			&cli.StringFlag{
+				Name:        "log-file",
+				Usage:       "file the server logs to instead of stderr, servers started in the background default to $XDG_STATE_HOME/hbt/" + internal.LogName,
+				Destination: &internal.LogFile,
+				EnvVars:     []string{internal.LogFileName},
+			},
+			&cli.StringFlag{
+				Name:        "log-format",
+				Usage:       "format of the logs (text, json)",
+				DefaultText: internal.DefaultLogFormat,
+				Value:       internal.DefaultLogFormat,
+				Destination: &internal.LogFormat,
+				EnvVars:     []string{internal.LogFormatName},
+			},
+			&cli.StringFlag{
+				Name:        "log-level",
+				Usage:       "minimum level of the logs (debug, info, warn, error)",
+				DefaultText: internal.DefaultLogLevel,
+				Value:       internal.DefaultLogLevel,
+				Destination: &internal.LogLevel,
+				EnvVars:     []string{internal.LogLevelName},
+			},
+			&cli.IntFlag{
+				Name:        "log-max-size",
+				Usage:       "size in megabytes beyond which the log file is rotated, 0 disables it",
+				DefaultText: fmt.Sprint(internal.DefaultLogMaxSize),
+				Value:       internal.DefaultLogMaxSize,
+				Destination: &internal.LogMaxSize,
+				EnvVars:     []string{internal.LogMaxSizeName},
+			},
+			&cli.IntFlag{
+				Name:        "log-backups",
+				Usage:       "how many rotated log files to keep",
+				DefaultText: fmt.Sprint(internal.DefaultLogBackups),
+				Value:       internal.DefaultLogBackups,
+				Destination: &internal.LogBackups,
+				EnvVars:     []string{internal.LogBackupsName},
+			},
 			&cli.StringFlag{
 				Name:        "cache",
 				Aliases:     []string{"c"},
-				DefaultText: "binary location",
-				Value:       internal.DefaultCachePath,
+				Usage:       "directory of the cache",
+				DefaultText: "$XDG_DATA_HOME/hbt",
+				Value:       xdg.DataDir(),
 				Destination: &internal.CachePath,
 				EnvVars:     []string{internal.CachePathName},
 			},
+			&cli.StringFlag{
+				Name:        "state-dir",
+				Usage:       "directory of the sync state",
+				DefaultText: "$XDG_STATE_HOME/hbt",
+				Value:       xdg.StateDir(),
+				Destination: &internal.StateDir,
+				EnvVars:     []string{internal.StateDirName},
+			},
+			&cli.StringFlag{
+				Name:        "runtime-dir",
+				Usage:       "directory of the pid file",
+				DefaultText: "$XDG_RUNTIME_DIR/hbt, or the state directory",
+				Value:       xdg.RuntimeDir(),
+				Destination: &internal.RuntimeDir,
+				EnvVars:     []string{internal.RuntimeDirName},
+			},
 			&cli.StringFlag{
 				Name:        "port",
 				Aliases:     []string{"p"},
@@ -51,35 +136,650 @@ var (
 				Destination: &internal.SaveInterval,
 				EnvVars:     []string{internal.SaveIntervalName},
 			},
+			&cli.StringFlag{
+				Name:        "cache-encoding",
+				Usage:       "encoding used to save the cache (json, binary), loading detects it",
+				DefaultText: internal.DefaultCacheEncoding,
+				Value:       internal.DefaultCacheEncoding,
+				Destination: &internal.CacheEncoding,
+				EnvVars:     []string{internal.CacheEncodingName},
+			},
+			&cli.BoolFlag{
+				Name:        "cache-compress",
+				Usage:       "gzip the cache when saving it",
+				DefaultText: "false",
+				Destination: &internal.CacheCompress,
+				EnvVars:     []string{internal.CacheCompressName},
+			},
+			&cli.IntFlag{
+				Name:        "backups",
+				Usage:       "how many previous versions of the cache to keep, used to recover from corruption",
+				DefaultText: fmt.Sprint(internal.DefaultBackups),
+				Value:       internal.DefaultBackups,
+				Destination: &internal.Backups,
+				EnvVars:     []string{internal.BackupsName},
+			},
+			&cli.StringFlag{
+				Name:        "sync-dir",
+				Usage:       "directory shared with other hosts (e.g. through Dropbox or Syncthing) to exchange what is learned",
+				Destination: &internal.SyncDir,
+				EnvVars:     []string{internal.SyncDirName},
+			},
+			&cli.StringFlag{
+				Name:        "sync-host",
+				Usage:       "name identifying this host in the sync directory",
+				DefaultText: "hostname",
+				Destination: &internal.SyncHost,
+				EnvVars:     []string{internal.SyncHostName},
+			},
+			&cli.DurationFlag{
+				Name:        "sync-interval",
+				Usage:       "how often to sync, it must be positive",
+				DefaultText: internal.DefaultSyncInterval.String(),
+				Value:       internal.DefaultSyncInterval,
+				Destination: &internal.SyncInterval,
+				EnvVars:     []string{internal.SyncIntervalName},
+			},
+			&cli.StringFlag{
+				Name:        "cache-key-file",
+				Usage:       "encrypt the cache with the key in this file, " + internal.CacheKeyName + " can hold the key itself",
+				Destination: &internal.CacheKeyFile,
+				EnvVars:     []string{internal.CacheKeyFileName},
+			},
+			&cli.StringFlag{
+				Name:        "redact",
+				Usage:       "what to do with commands containing secrets (mask, drop, off)",
+				DefaultText: internal.DefaultRedact,
+				Value:       internal.DefaultRedact,
+				Destination: &internal.Redact,
+				EnvVars:     []string{internal.RedactName},
+			},
+			&cli.StringSliceFlag{
+				Name:        "redact-rule",
+				Usage:       "additional regular expression matching secrets, a group named secret restricts what is masked",
+				Destination: redactRules,
+				EnvVars:     []string{internal.RedactRulesName},
+			},
+			&cli.StringSliceFlag{
+				Name:        "ignore-command",
+				Usage:       "do not track commands matching this glob, or regular expression if prefixed with " + ignore.RegexPrefix,
+				Destination: ignoreCommands,
+				EnvVars:     []string{internal.IgnoreCommandsName},
+			},
+			&cli.StringSliceFlag{
+				Name:        "ignore-dir",
+				Usage:       "do not track commands run in this directory or its subdirectories",
+				Destination: ignoreDirs,
+				EnvVars:     []string{internal.IgnoreDirsName},
+			},
+			&cli.BoolFlag{
+				Name:        "ignore-space",
+				Usage:       "do not track commands starting with a space, like zsh HIST_IGNORE_SPACE",
+				Value:       true,
+				DefaultText: "true",
+				Destination: &internal.IgnoreSpace,
+				EnvVars:     []string{internal.IgnoreSpaceName},
+			},
+			&cli.DurationFlag{
+				Name:        "prune-interval",
+				Usage:       "how often to prune stale knowledge, 0 disables it",
+				DefaultText: internal.DefaultPruneInterval.String(),
+				Value:       internal.DefaultPruneInterval,
+				Destination: &internal.PruneInterval,
+				EnvVars:     []string{internal.PruneIntervalName},
+			},
+			&cli.DurationFlag{
+				Name:        "prune-max-age",
+				Usage:       "prune commands not used for longer than this",
+				DefaultText: "disabled",
+				Destination: &internal.PruneMaxAge,
+				EnvVars:     []string{internal.PruneMaxAgeName},
+			},
+			&cli.IntFlag{
+				Name:        "prune-min-hits",
+				Usage:       "prune commands with fewer hits, once unused for " + internal.DefaultPruneMinHitsGrace.String(),
+				DefaultText: "disabled",
+				Destination: &internal.PruneMinHits,
+				EnvVars:     []string{internal.PruneMinHitsName},
+			},
+			&cli.IntFlag{
+				Name:        "prune-max-edges",
+				Usage:       "keep at most this many commands per directory",
+				DefaultText: "disabled",
+				Destination: &internal.PruneMaxEdges,
+				EnvVars:     []string{internal.PruneMaxEdgesName},
+			},
+			&cli.IntFlag{
+				Name:        "prune-max-nodes",
+				Usage:       "keep at most this many directories",
+				DefaultText: "disabled",
+				Destination: &internal.PruneMaxNodes,
+				EnvVars:     []string{internal.PruneMaxNodesName},
+			},
+			&cli.DurationFlag{
+				Name:        "session-ttl",
+				Usage:       "end sessions without activity for this long, 0 disables it",
+				DefaultText: internal.DefaultSessionTTL.String(),
+				Value:       internal.DefaultSessionTTL,
+				Destination: &internal.SessionTTL,
+				EnvVars:     []string{internal.SessionTTLName},
+			},
+			&cli.DurationFlag{
+				Name:        "client-timeout",
+				Usage:       "how long commands talking to the running server wait for it",
+				Value:       internal.DefaultClientTimeout,
+				Destination: &internal.ClientTimeout,
+				EnvVars:     []string{internal.ClientTimeoutName},
+			},
+			&cli.DurationFlag{
+				Name:        "idle-exit",
+				Usage:       "save and exit after handling no request for this long, meant for socket activation",
+				DefaultText: "disabled",
+				Destination: &internal.IdleExit,
+				EnvVars:     []string{internal.IdleExitName},
+			},
+			&cli.StringFlag{
+				Name:        "graph",
+				Usage:       "how commands are learned and suggested (naive)",
+				DefaultText: internal.DefaultGraph,
+				Value:       internal.DefaultGraph,
+				Destination: &internal.Graph,
+				EnvVars:     []string{internal.GraphName},
+			},
+			&cli.IntFlag{
+				Name:        "graph-max-history",
+				Usage:       "how many commands are remembered per session",
+				DefaultText: fmt.Sprint(internal.DefaultGraphMaxHistory),
+				Value:       internal.DefaultGraphMaxHistory,
+				Destination: &internal.GraphMaxHistory,
+				EnvVars:     []string{internal.GraphMaxHistoryName},
+			},
+			&cli.IntFlag{
+				Name:        "graph-min-common-path",
+				Usage:       "how many trailing directories an unknown directory must share with a known one to get its hints",
+				DefaultText: fmt.Sprint(internal.DefaultGraphMinCommonPath),
+				Value:       internal.DefaultGraphMinCommonPath,
+				Destination: &internal.GraphMinCommonPath,
+				EnvVars:     []string{internal.GraphMinCommonPathName},
+			},
 		},
-		Before: func(_ *cli.Context) error {
+		Before: func(c *cli.Context) error {
+			if err := applyConfig(c); err != nil {
+				return err
+			}
+			// Only the server logs to the log file, commands log to stderr.
+			if _, err := setupLogging(""); err != nil {
+				return err
+			}
 			cachePath = path.Join(internal.CachePath, internal.CacheName)
-			g = naive.NewGraph(10, 3)
-			return g.Load(cachePath)
+			client.Timeout = internal.ClientTimeout
+			return setCacheKey()
 		},
 		// By default start a server
-		Action: func(_ *cli.Context) error {
-			return server.Start(g, cachePath)
+		Action: func(c *cli.Context) error {
+			logFile, err := setupLogging(internal.LogFile)
+			if err != nil {
+				return err
+			}
+			defer logFile.Close() //nolint:errcheck // It is okay.
+			for _, dir := range []string{internal.CachePath, internal.RuntimeDir} {
+				if err = os.MkdirAll(dir, 0o700); err != nil {
+					return err
+				}
+			}
+			// The cache is locked before it is migrated or loaded.
+			lock, err := daemon.Acquire(lockPath(), pidPath())
+			if err != nil {
+				return err
+			}
+			defer lock.Release() //nolint:errcheck // It is okay.
+			if err = migrate(); err != nil {
+				return err
+			}
+			if err = loadGraph(); err != nil {
+				return err
+			}
+			srv.SetReloader(reloader(c))
+			var syncer *syncdir.Syncer
+			if internal.SyncDir != "" {
+				if syncer, err = startSync(); err != nil {
+					return err
+				}
+			}
+			err = srv.Start()
+			if syncer != nil {
+				// Do not wait for the next sync to share the last commands.
+				if serr := syncer.Export(); serr != nil {
+					slog.Error("Cannot export delta", "dir", internal.SyncDir, "error", serr)
+				}
+			}
+			return err
 		},
 		Commands: []*cli.Command{
 			{
 				Name:    "cli",
 				Aliases: []string{"c"},
-				Usage:   "run a command without starting a server",
+				Usage:   "run a command without starting a server, a running server does not see its changes",
+				Before:  load,
+				After:   unlockCache,
+				Action: func(c *cli.Context) error {
+					result, err := srv.ProcessCommand(c.Args().Slice())
+					if err != nil {
+						return err
+					}
+					fmt.Println(result)
+					return nil
+				},
+			},
+			{
+				Name:   "prune",
+				Usage:  "prune stale knowledge from the cache, according to the prune flags",
+				Before: load,
+				After:  unlockCache,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only report what would be pruned",
+					},
+				},
 				Action: func(c *cli.Context) error {
-					result, err := server.ProcessCommand(c.Args().Slice(), g)
+					result, err := srv.Prune(c.Bool("dry-run"))
 					if err != nil {
 						return err
 					}
 					fmt.Println(result)
+					if c.Bool("dry-run") {
+						return nil
+					}
+					return g.Save(cachePath)
+				},
+			},
+			{
+				Name:   "stats",
+				Usage:  "describe how much has been learned",
+				Before: load,
+				After:  unlockCache,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "top",
+						Usage: "how many commands and directories to list",
+						Value: server.DefaultStatsTop,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					stats, err := srv.Stats(c.Int("top"))
+					if err != nil {
+						return err
+					}
+					fmt.Println(stats)
+					return nil
+				},
+			},
+			{
+				Name:      "forget",
+				Usage:     "remove the commands containing a pattern from the cache and its backups",
+				Before:    load,
+				After:     unlockCache,
+				ArgsUsage: "PATTERN",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "regex",
+						Usage: "the pattern is a regular expression",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only report what would be removed",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return NewErrWrongUsage("forget [--regex] [--dry-run] PATTERN")
+					}
+					report, err := srv.Forget(c.Args().First(), c.Bool("regex"), c.Bool("dry-run"))
+					if err != nil {
+						return err
+					}
+					fmt.Println(report)
 					return nil
 				},
 			},
+			{
+				Name:      "init",
+				Usage:     "print the script integrating hbt in a shell, e.g. eval \"$(hbtsrv init zsh)\"",
+				ArgsUsage: "SHELL",
+				Action:    initShell,
+			},
+			ctl,
+			clientCommand,
+			daemonCommand,
+			configCommand,
+			{
+				Name:      "sessions",
+				Usage:     "list the sessions of the running server, or describe one of them",
+				ArgsUsage: "[ID]",
+				Action: func(c *cli.Context) error {
+					args := []string{"sessions"}
+					switch c.NArg() {
+					case 0:
+					case 1:
+						args = []string{"session", c.Args().First()}
+					default:
+						return NewErrWrongUsage("sessions [ID]")
+					}
+					return send(args...)(c)
+				},
+			},
+			{
+				Name:      "explain",
+				Usage:     "explain the next hint the running server would give to a session",
+				ArgsUsage: "ID [DIR]",
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 || c.NArg() > 2 {
+						return NewErrWrongUsage("explain ID [DIR]")
+					}
+					wd := c.Args().Get(1)
+					if wd == "" {
+						var err error
+						if wd, err = os.Getwd(); err != nil {
+							return err
+						}
+					}
+					return send("explain", c.Args().First(), wd)(c)
+				},
+			},
+			{
+				Name:      "merge",
+				Usage:     "merge several caches into a new one",
+				ArgsUsage: "CACHE...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "where to save the merged cache",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:        "strategy",
+						Usage:       "how to combine the hits of a command known by several caches (sum, max)",
+						Value:       string(naive.MergeSum),
+						DefaultText: string(naive.MergeSum),
+					},
+					&cli.StringSliceFlag{
+						Name:  "remap",
+						Usage: "rewrite a directory prefix while merging, in the from=to form (e.g. /Users/x=/home/x)",
+					},
+				},
+				Action: merge,
+			},
 		},
 	}
 )
 
+func initShell(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return NewErrWrongUsage("init " + strings.Join(shell.Shells(), "|"))
+	}
+	binary, err := executable()
+	if err != nil {
+		return err
+	}
+	// The directories are left out unless configured, so that the defaults
+	// follow the environment.
+	p := shell.Params{Binary: binary, Port: internal.Port}
+	if configSources["cache"].kind != sourceDefault {
+		if p.CachePath, err = filepath.Abs(internal.CachePath); err != nil {
+			return err
+		}
+	}
+	if configSources["runtime-dir"].kind != sourceDefault {
+		if p.RuntimeDir, err = filepath.Abs(internal.RuntimeDir); err != nil {
+			return err
+		}
+	}
+	return shell.Write(os.Stdout, c.Args().First(), p)
+}
+
+// executable returns the path of the running binary. When it was found
+// through PATH, the path is not resolved, so that it survives upgrades of
+// package managers which link to versioned directories.
+func executable() (string, error) {
+	if !strings.ContainsRune(os.Args[0], filepath.Separator) {
+		if p, err := exec.LookPath(os.Args[0]); err == nil {
+			return filepath.Abs(p)
+		}
+	}
+	return os.Executable()
+}
+
+// load sets up the graph and the server for the commands working on the
+// cache.
+// cacheWriters lists the requests of the cli command writing the cache.
+var cacheWriters = map[string]bool{"save-now": true, "forget": true, "shutdown": true}
+
+// load loads the cache for the commands working on it without a server. They
+// lock it like a server, so that the cache is only migrated or written by its
+// owner. When a server owns it, the commands which would write it fail,
+// pointing to the request doing the same through the server, and the others
+// read it as it is.
+func load(c *cli.Context) error {
+	if err := os.MkdirAll(internal.CachePath, 0o700); err != nil {
+		return err
+	}
+	lock, err := daemon.Acquire(lockPath(), "")
+	switch {
+	case err == nil:
+		cacheLock = lock
+		if err = migrate(); err != nil {
+			return err
+		}
+	case errors.Is(err, daemon.ErrRunning):
+		if request := cacheRequest(c); request != "" {
+			return fmt.Errorf("%w, use hbt ctl %s instead or stop it first", err, request)
+		}
+	default:
+		return err
+	}
+	return loadGraph()
+}
+
+// cacheRequest returns the request of the server doing what c does, if c
+// writes the cache.
+func cacheRequest(c *cli.Context) string {
+	switch c.Command.Name {
+	case "prune", "forget":
+		if !c.Bool("dry-run") {
+			return c.Command.Name
+		}
+	case "cli":
+		if cacheWriters[c.Args().First()] {
+			return c.Args().First()
+		}
+	}
+	return ""
+}
+
+// unlockCache releases the lock taken by load, if any.
+func unlockCache(_ *cli.Context) error {
+	if cacheLock == nil {
+		return nil
+	}
+	err := cacheLock.Release()
+	cacheLock = nil
+	return err
+}
+
+// loadGraph loads the cache into a new graph, served by a new server.
+func loadGraph() error {
+	if err := os.MkdirAll(internal.CachePath, 0o700); err != nil {
+		return err
+	}
+	s := currentSettings()
+	ng, err := s.newGraph()
+	if err != nil {
+		return err
+	}
+	g = ng
+	if err = g.Load(cachePath); err != nil {
+		return err
+	}
+	filters, err := s.newFilters()
+	if err != nil {
+		return err
+	}
+	srv = server.New(g, cachePath)
+	srv.SetFilters(filters...)
+	srv.SetPruneOptions(s.pruneOptions())
+	if internal.SyncDir != "" {
+		host, err := syncHost()
+		if err != nil {
+			return err
+		}
+		srv.SetOwnFiles(func() ([]string, error) {
+			return syncdir.OwnDeltas(internal.SyncDir, host)
+		})
+	}
+	return nil
+}
+
+// setupLogging makes the logs follow the flags, writing them to filePath
+// unless it is empty.
+func setupLogging(filePath string) (io.Closer, error) {
+	level := internal.LogLevel
+	if internal.Debug {
+		level = "debug"
+	}
+	return logging.Setup(logging.Options{
+		Level:   level,
+		Format:  internal.LogFormat,
+		File:    filePath,
+		MaxSize: int64(internal.LogMaxSize) << 20,
+		Backups: internal.LogBackups,
+	})
+}
+
+// newGraph returns an empty graph of the configured implementation, saved
+// with the configured encoding.
+func (s settings) newGraph() (*naive.Graph, error) {
+	if s.graph != internal.DefaultGraph {
+		return nil, fmt.Errorf("unknown graph implementation %q", s.graph)
+	}
+	if s.graphMaxHistory <= 0 {
+		return nil, fmt.Errorf("invalid graph max history %d, it must be positive", s.graphMaxHistory)
+	}
+	enc, err := naive.ParseEncoding(s.cacheEncoding)
+	if err != nil {
+		return nil, err
+	}
+	ng := naive.NewGraph(s.graphMaxHistory, s.graphMinCommonPath)
+	ng.Encoding = enc
+	ng.Compress = s.cacheCompress
+	return ng, nil
+}
+
 // Run the root command with the given arguments.
 func Run(arguments []string) error {
 	return root.Run(arguments)
 }
+
+func merge(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return ErrNotEnoughArguments
+	}
+	strategy, err := naive.ParseMergeStrategy(c.String("strategy"))
+	if err != nil {
+		return err
+	}
+	opts := naive.MergeOptions{Strategy: strategy}
+	for _, r := range c.StringSlice("remap") {
+		remap, err := naive.ParseRemap(r)
+		if err != nil {
+			return NewErrWrongUsage("--remap from=to")
+		}
+		opts.Remaps = append(opts.Remaps, remap)
+	}
+	s := currentSettings()
+	merged, err := s.newGraph()
+	if err != nil {
+		return err
+	}
+	for _, filePath := range c.Args().Slice() {
+		other, _ := s.newGraph()
+		if err = other.LoadStrict(filePath); err != nil {
+			return err
+		}
+		merged.Merge(other, opts)
+	}
+	return merged.Save(c.String("output"))
+}
+
+// startSync shares what the server learns through the sync directory.
+func startSync() (*syncdir.Syncer, error) {
+	sg, ok := g.(syncdir.Graph)
+	if !ok {
+		return nil, fmt.Errorf("graph %T cannot be synchronised", g)
+	}
+	host, err := syncHost()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(internal.StateDir, 0o700); err != nil {
+		return nil, err
+	}
+	s, err := syncdir.New(sg, internal.SyncDir, host, path.Join(internal.StateDir, internal.SyncStateName))
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Start(internal.SyncInterval); err != nil {
+		return nil, err
+	}
+	srv.SetSaveHook(s.Checkpoint)
+	return s, nil
+}
+
+// syncHost returns the name this host shares its deltas under.
+func syncHost() (string, error) {
+	if internal.SyncHost != "" {
+		return internal.SyncHost, nil
+	}
+	return os.Hostname()
+}
+
+// setCacheKey enables the encryption of the cache if a key is configured.
+// The key is deliberately not accepted as a flag, so that it does not show up
+// in the process list.
+func setCacheKey() error {
+	if internal.CacheKeyFile != "" {
+		return cache.SetKeyFile(internal.CacheKeyFile)
+	}
+	return cache.SetKey(os.Getenv(internal.CacheKeyName))
+}
+
+// newFilters returns the filters configured by the flags, in the order they
+// must be applied.
+func (s settings) newFilters() ([]server.Filter, error) {
+	rules, err := ignore.New(s.ignoreCommands, s.ignoreDirs, s.ignoreSpace)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := redact.ParseMode(s.redact)
+	if err != nil {
+		return nil, err
+	}
+	redactor, err := redact.New(mode, s.redactRules)
+	if err != nil {
+		return nil, err
+	}
+	// Ignore first, there is no point in redacting a command which is not
+	// tracked.
+	return []server.Filter{rules, redactor}, nil
+}
+
+func (s settings) pruneOptions() graph.PruneOptions {
+	return graph.PruneOptions{
+		MaxAge:          s.pruneMaxAge,
+		MinHits:         s.pruneMinHits,
+		MinHitsGrace:    internal.DefaultPruneMinHitsGrace,
+		MaxEdgesPerNode: s.pruneMaxEdges,
+		MaxNodes:        s.pruneMaxNodes,
+	}
+}
@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lzambarda/hbt/config"
+	"github.com/lzambarda/hbt/graph/naive"
+	"github.com/lzambarda/hbt/internal"
+	"github.com/lzambarda/hbt/server"
+	"github.com/urfave/cli/v2"
+)
+
+// Where the value of a global flag comes from, by order of precedence.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// configKeys maps the keys of the configuration file which are not named after
+// their flag, as a TOML key cannot also be a table.
+var configKeys = map[string]string{
+	"cache-path":           "cache",
+	"graph-implementation": "graph",
+}
+
+// flagSource tells where the value of a global flag comes from.
+type flagSource struct {
+	kind string
+	// The environment variable or the configuration file.
+	detail string
+}
+
+func (s flagSource) String() string {
+	if s.detail == "" {
+		return s.kind
+	}
+	return s.kind + " (" + s.detail + ")"
+}
+
+var (
+	// The configuration file in use, empty if there is none.
+	configPath string
+	// The source of each global flag, by name.
+	configSources = map[string]flagSource{}
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "inspect the configuration",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "show",
+			Usage:  "print the effective configuration and where each setting comes from",
+			Action: showConfig,
+		},
+	},
+}
+
+// applyConfig records where each global flag comes from, then applies the
+// configuration file.
+func applyConfig(c *cli.Context) error {
+	visited := map[string]bool{}
+	for _, name := range c.LocalFlagNames() {
+		visited[name] = true
+	}
+	for _, f := range globalFlags(c) {
+		name := f.Names()[0]
+		switch {
+		case visited[name]:
+			configSources[name] = flagSource{kind: sourceFlag}
+		case f.IsSet():
+			configSources[name] = flagSource{sourceEnv, envVar(f)}
+		default:
+			configSources[name] = flagSource{kind: sourceDefault}
+		}
+	}
+	filePath, settings, err := readConfigFile(c)
+	if err != nil {
+		return err
+	}
+	configPath = filePath
+	for _, s := range settings {
+		name := flagName(s.Key)
+		if err = setFlag(c, lookupFlag(c, name), s.Values); err != nil {
+			return fmt.Errorf("%s: %s: %w", filePath, s.Key, err)
+		}
+		configSources[name] = flagSource{sourceFile, filePath}
+	}
+	return nil
+}
+
+// readConfigFile returns the path of the configuration file, empty if there is
+// none, and its settings for the global flags which are neither given on the
+// command line nor through the environment. It does not change any flag.
+func readConfigFile(c *cli.Context) (string, []config.Setting, error) {
+	// Only the default file is optional.
+	filePath := internal.ConfigPath
+	if filePath == "" {
+		filePath = config.DefaultPath()
+		if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+			return "", nil, nil
+		}
+	}
+	all, err := config.Load(filePath)
+	if err != nil {
+		return "", nil, err
+	}
+	var settings []config.Setting
+	for _, s := range all {
+		if err = checkSetting(c, s); err != nil {
+			return "", nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		switch configSources[flagName(s.Key)].kind {
+		case sourceFlag, sourceEnv:
+		default:
+			settings = append(settings, s)
+		}
+	}
+	return filePath, settings, nil
+}
+
+// flagName returns the name of the global flag set by a key of the
+// configuration file.
+func flagName(key string) string {
+	if n, ok := configKeys[key]; ok {
+		return n
+	}
+	return key
+}
+
+func checkSetting(c *cli.Context, s config.Setting) error {
+	name := flagName(s.Key)
+	f := lookupFlag(c, name)
+	if f == nil || name == "config" {
+		return fmt.Errorf("unknown setting %q", s.Key)
+	}
+	if _, slice := f.(*cli.StringSliceFlag); s.Array && !slice {
+		return fmt.Errorf("%s takes a single value", s.Key)
+	}
+	return nil
+}
+
+// setFlag replaces the values of a global flag.
+func setFlag(c *cli.Context, f cli.Flag, values []string) error {
+	name := f.Names()[0]
+	if _, ok := f.(*cli.StringSliceFlag); ok {
+		// A serialised slice replaces the values rather than adding to them.
+		return c.Set(name, cli.NewStringSlice(values...).Serialize())
+	}
+	return c.Set(name, values[0])
+}
+
+// defaultValues returns the values of a global flag when it is not set.
+func defaultValues(f cli.Flag) []string {
+	switch f := f.(type) {
+	case *cli.StringSliceFlag:
+		if f.Value == nil {
+			return nil
+		}
+		return f.Value.Value()
+	case *cli.BoolFlag:
+		return []string{strconv.FormatBool(f.Value)}
+	case *cli.IntFlag:
+		return []string{strconv.Itoa(f.Value)}
+	case *cli.DurationFlag:
+		return []string{f.Value.String()}
+	case *cli.StringFlag:
+		return []string{f.Value}
+	}
+	return []string{f.(cli.DocGenerationFlag).GetValue()}
+}
+
+// settings are those a reload applies to a running server, other settings
+// like the port or the cache path need a restart.
+//
+//nolint:govet // Grouped like the flags.
+type settings struct {
+	ignoreCommands     []string
+	ignoreDirs         []string
+	ignoreSpace        bool
+	redact             string
+	redactRules        []string
+	pruneMaxAge        time.Duration
+	pruneMinHits       int
+	pruneMaxEdges      int
+	pruneMaxNodes      int
+	graph              string
+	graphMaxHistory    int
+	graphMinCommonPath int
+	cacheEncoding      string
+	cacheCompress      bool
+}
+
+// currentSettings returns the settings the process started with.
+func currentSettings() settings {
+	return settings{
+		ignoreCommands:     ignoreCommands.Value(),
+		ignoreDirs:         ignoreDirs.Value(),
+		ignoreSpace:        internal.IgnoreSpace,
+		redact:             internal.Redact,
+		redactRules:        redactRules.Value(),
+		pruneMaxAge:        internal.PruneMaxAge,
+		pruneMinHits:       internal.PruneMinHits,
+		pruneMaxEdges:      internal.PruneMaxEdges,
+		pruneMaxNodes:      internal.PruneMaxNodes,
+		graph:              internal.Graph,
+		graphMaxHistory:    internal.GraphMaxHistory,
+		graphMinCommonPath: internal.GraphMinCommonPath,
+		cacheEncoding:      internal.CacheEncoding,
+		cacheCompress:      internal.CacheCompress,
+	}
+}
+
+// fields maps the name of the flag of each setting to where it is stored.
+func (s *settings) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"ignore-command":        &s.ignoreCommands,
+		"ignore-dir":            &s.ignoreDirs,
+		"ignore-space":          &s.ignoreSpace,
+		"redact":                &s.redact,
+		"redact-rule":           &s.redactRules,
+		"prune-max-age":         &s.pruneMaxAge,
+		"prune-min-hits":        &s.pruneMinHits,
+		"prune-max-edges":       &s.pruneMaxEdges,
+		"prune-max-nodes":       &s.pruneMaxNodes,
+		"graph":                 &s.graph,
+		"graph-max-history":     &s.graphMaxHistory,
+		"graph-min-common-path": &s.graphMinCommonPath,
+		"cache-encoding":        &s.cacheEncoding,
+		"cache-compress":        &s.cacheCompress,
+	}
+}
+
+// parseValue parses values the way their flag would into field.
+func parseValue(field interface{}, values []string) (err error) {
+	switch field := field.(type) {
+	case *[]string:
+		*field = values
+	case *string:
+		*field = values[0]
+	case *bool:
+		*field, err = strconv.ParseBool(values[0])
+	case *int:
+		var i int64
+		i, err = strconv.ParseInt(values[0], 0, 64)
+		*field = int(i)
+	case *time.Duration:
+		*field, err = time.ParseDuration(values[0])
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", field))
+	}
+	return err
+}
+
+// reloadSettings returns the settings according to the configuration file,
+// those it does not set being back to their default, unless they are given on
+// the command line or through the environment. The flags are left untouched,
+// as the running server reads them.
+func reloadSettings(c *cli.Context) (settings, error) {
+	filePath, fileSettings, err := readConfigFile(c)
+	if err != nil {
+		return settings{}, err
+	}
+	s := currentSettings()
+	fields := s.fields()
+	for name, field := range fields {
+		switch configSources[name].kind {
+		case sourceFile, sourceDefault:
+			if err = parseValue(field, defaultValues(lookupFlag(c, name))); err != nil {
+				return settings{}, err
+			}
+		}
+	}
+	for _, fs := range fileSettings {
+		field, ok := fields[flagName(fs.Key)]
+		if !ok {
+			continue
+		}
+		if err = parseValue(field, fs.Values); err != nil {
+			return settings{}, fmt.Errorf("%s: %s: %w", filePath, fs.Key, err)
+		}
+	}
+	return s, nil
+}
+
+// reloader re-reads the configuration file, then returns the state of the
+// server according to it, see settings.
+// The graph is configured in place, so what it learned, sessions included, is
+// kept.
+func reloader(c *cli.Context) server.Reloader {
+	return func(current server.Graph) (server.Config, error) {
+		if _, ok := current.(*naive.Graph); !ok {
+			return server.Config{}, fmt.Errorf("graph %T cannot be reconfigured", current)
+		}
+		s, err := reloadSettings(c)
+		if err != nil {
+			return server.Config{}, err
+		}
+		filters, err := s.newFilters()
+		if err != nil {
+			return server.Config{}, err
+		}
+		ng, err := s.newGraph()
+		if err != nil {
+			return server.Config{}, err
+		}
+		return server.Config{
+			Filters:      filters,
+			PruneOptions: s.pruneOptions(),
+			Configure: func(g server.Graph) {
+				g.(*naive.Graph).Configure(ng)
+			},
+		}, nil
+	}
+}
+
+// globalFlags returns the flags of the root command, minus help and version.
+// c.App does not hold them in commands having subcommands, which run as apps
+// of their own.
+func globalFlags(c *cli.Context) []cli.Flag {
+	var all []cli.Flag
+	for _, ctx := range c.Lineage() {
+		if ctx.App != nil {
+			all = ctx.App.Flags
+		}
+	}
+	flags := make([]cli.Flag, 0, len(all))
+	for _, f := range all {
+		if f != cli.HelpFlag && f != cli.VersionFlag {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+func lookupFlag(c *cli.Context, name string) cli.Flag {
+	for _, f := range globalFlags(c) {
+		if f.Names()[0] == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func envVar(f cli.Flag) string {
+	var vars []string
+	switch f := f.(type) {
+	case *cli.StringFlag:
+		vars = f.EnvVars
+	case *cli.StringSliceFlag:
+		vars = f.EnvVars
+	case *cli.BoolFlag:
+		vars = f.EnvVars
+	case *cli.IntFlag:
+		vars = f.EnvVars
+	case *cli.DurationFlag:
+		vars = f.EnvVars
+	}
+	for _, v := range vars {
+		if _, ok := os.LookupEnv(v); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// flagValues returns the values of a global flag, as they would be given on
+// the command line.
+func flagValues(c *cli.Context, f cli.Flag) []string {
+	name := f.Names()[0]
+	switch f.(type) {
+	case *cli.StringSliceFlag:
+		return c.StringSlice(name)
+	case *cli.BoolFlag:
+		return []string{strconv.FormatBool(c.Bool(name))}
+	case *cli.IntFlag:
+		return []string{strconv.Itoa(c.Int(name))}
+	case *cli.DurationFlag:
+		return []string{c.Duration(name).String()}
+	default:
+		return []string{c.String(name)}
+	}
+}
+
+func showConfig(c *cli.Context) error {
+	if configPath == "" {
+		fmt.Println("config file: none, looked for", config.DefaultPath())
+	} else {
+		fmt.Println("config file:", configPath)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE") //nolint:errcheck // It is okay.
+	for _, f := range globalFlags(c) {
+		name := f.Names()[0]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, strings.Join(flagValues(c, f), ","), configSources[name]) //nolint:errcheck // It is okay.
+	}
+	return w.Flush()
+}
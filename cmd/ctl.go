@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/lzambarda/hbt/client"
+	"github.com/lzambarda/hbt/internal"
+	"github.com/lzambarda/hbt/server"
+	"github.com/urfave/cli/v2"
+)
+
+// ctl sends control requests to the running server, unlike the commands
+// working on the cache directly, whose changes the server would overwrite.
+var ctl = &cli.Command{
+	Name:  "ctl",
+	Usage: "control the running server",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "save-now",
+			Usage:  "save the cache right away",
+			Action: send("save-now"),
+		},
+		{
+			Name:  "reload",
+			Usage: "re-read the configuration file, like SIGHUP",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "cache",
+					Usage: "also replace what the server knows with the content of the cache",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				args := []string{"reload"}
+				if c.Bool("cache") {
+					args = append(args, "cache")
+				}
+				return send(args...)(c)
+			},
+		},
+		{
+			Name:  "stats",
+			Usage: "describe how much has been learned",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "top",
+					Usage: "how many commands and directories to list",
+					Value: server.DefaultStatsTop,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return send("stats", strconv.Itoa(c.Int("top")))(c)
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "prune stale knowledge, according to the prune flags of the server",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only report what would be pruned",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				args := []string{"prune"}
+				if c.Bool("dry-run") {
+					args = append(args, "dry-run")
+				}
+				return send(args...)(c)
+			},
+		},
+		{
+			Name:      "forget",
+			Usage:     "remove the commands containing a pattern from the cache and its backups",
+			ArgsUsage: "PATTERN",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "regex",
+					Usage: "the pattern is a regular expression",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only report what would be removed",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return NewErrWrongUsage("ctl forget [--regex] [--dry-run] PATTERN")
+				}
+				args := []string{"forget"}
+				if c.Bool("regex") {
+					args = append(args, "re")
+				}
+				if c.Bool("dry-run") {
+					args = append(args, "dry-run")
+				}
+				return send(append(args, c.Args().First())...)(c)
+			},
+		},
+		{
+			Name:   "shutdown",
+			Usage:  "save the cache and stop the server",
+			Action: send("shutdown"),
+		},
+	},
+}
+
+// send returns an action sending args to the running server and printing its
+// response.
+func send(args ...string) cli.ActionFunc {
+	return func(_ *cli.Context) error {
+		result, err := client.Send(internal.Port, args...)
+		if err != nil {
+			return err
+		}
+		if result != "" {
+			fmt.Println(result)
+		}
+		return nil
+	}
+}
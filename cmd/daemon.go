@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/lzambarda/hbt/client"
+	"github.com/lzambarda/hbt/daemon"
+	"github.com/lzambarda/hbt/internal"
+	"github.com/urfave/cli/v2"
+)
+
+// How long to wait for a server to start or stop, loading a big cache takes a
+// while.
+const daemonTimeout = 10 * time.Second
+
+var daemonCommand = &cli.Command{
+	Name:  "daemon",
+	Usage: "manage the server running in the background",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "start",
+			Usage:  "start the server in the background, with the same flags, unless it is already running",
+			Action: startDaemon,
+		},
+		{
+			Name:   "stop",
+			Usage:  "save the cache and stop the server",
+			Action: stopDaemon,
+		},
+		{
+			Name:  "status",
+			Usage: "tell whether the server is running",
+			Action: func(_ *cli.Context) error {
+				pid, running, err := daemon.Running(lockPath())
+				if err != nil {
+					return err
+				}
+				if !running {
+					return cli.Exit("hbt is not running", ExitNoServer)
+				}
+				fmt.Printf("hbt is running with pid %d on port %s\n", pid, internal.Port)
+				return nil
+			},
+		},
+	},
+}
+
+// lockPath is where the server using the cache locks it, it tells whether it
+// is running.
+func lockPath() string {
+	return path.Join(internal.CachePath, internal.LockName)
+}
+
+func pidPath() string {
+	return path.Join(internal.RuntimeDir, internal.PidName)
+}
+
+// startDaemon starts a server in the background and waits for it to answer.
+// Several shells might try at once, in which case only one server gets to
+// lock the cache and the others exit.
+func startDaemon(c *cli.Context) error {
+	_, running, err := daemon.Running(lockPath())
+	if err != nil {
+		return err
+	}
+	// A running server might still be loading the cache, wait for it too.
+	var exited <-chan error
+	if !running {
+		if exited, err = spawnDaemon(c); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(daemonTimeout)
+	for time.Now().Before(deadline) {
+		if _, err = client.Send(internal.Port, "ping"); err == nil {
+			return nil
+		}
+		select {
+		case err = <-exited:
+			// Another server might have won the race, in which case wait for
+			// it instead.
+			if _, running, _ = daemon.Running(lockPath()); !running {
+				return fmt.Errorf("hbt exited right away: %v, run it in the foreground to see why", err) //nolint:errorlint // It is okay.
+			}
+			exited = nil
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("hbt did not start within %s", daemonTimeout)
+}
+
+// spawnDaemon starts a server in the background, the returned channel is sent
+// its exit status.
+func spawnDaemon(c *cli.Context) (<-chan error, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args, err := serverArgs(c)
+	if err != nil {
+		return nil, err
+	}
+	// Its output is discarded, so it logs to the state directory by default.
+	if configSources["log-file"].kind == sourceDefault {
+		p, err := filepath.Abs(filepath.Join(internal.StateDir, internal.LogName))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--log-file="+p)
+	}
+	cmd, err := daemon.Spawn(binary, args)
+	if err != nil {
+		return nil, err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	return exited, nil
+}
+
+// pathFlags are the global flags holding paths, which are made absolute when
+// passed to the server, as it does not run in the current directory.
+var pathFlags = map[string]bool{
+	"cache":       true,
+	"config":      true,
+	"state-dir":   true,
+	"runtime-dir": true,
+	"log-file":    true,
+}
+
+// serverArgs returns the global flags of the running command, which c looks
+// up in its parents, so that the server is started with the same
+// configuration. Those set by the configuration file are left to the server to
+// read, unless they are relative paths.
+func serverArgs(c *cli.Context) ([]string, error) {
+	var args []string
+	for _, f := range globalFlags(c) {
+		name := f.Names()[0]
+		switch source := configSources[name].kind; {
+		case source == sourceDefault:
+			continue
+		case pathFlags[name] && c.String(name) != "":
+			p, err := filepath.Abs(c.String(name))
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--"+name+"="+p)
+			continue
+		case source == sourceFile:
+			continue
+		}
+		for _, v := range flagValues(c, f) {
+			args = append(args, "--"+name+"="+v)
+		}
+	}
+	return args, nil
+}
+
+// stopDaemon asks the server to shut down, or terminates it if it does not
+// answer, then waits for it to release the cache.
+func stopDaemon(_ *cli.Context) error {
+	pid, running, err := daemon.Running(lockPath())
+	if err != nil {
+		return err
+	}
+	if !running {
+		slog.Debug("hbt is not running")
+		return nil
+	}
+	if _, err = client.Send(internal.Port, "shutdown"); err != nil {
+		var serverErr client.ServerError
+		if errors.As(err, &serverErr) || pid == 0 {
+			return err
+		}
+		if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(daemonTimeout)
+	for time.Now().Before(deadline) {
+		if _, running, err = daemon.Running(lockPath()); err != nil || !running {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("hbt did not stop within %s", daemonTimeout)
+}
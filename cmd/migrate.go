@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/lzambarda/hbt/cache"
+	"github.com/lzambarda/hbt/internal"
+)
+
+// migrate moves the files older versions kept in the cache directory, which
+// defaulted to the current directory, to where they belong now.
+func migrate() error {
+	dirs := []string{internal.CachePath}
+	legacy, err := migrateCache()
+	if err != nil {
+		return err
+	}
+	if legacy != "" {
+		dirs = append(dirs, legacy)
+	}
+	return migrateSyncState(dirs)
+}
+
+// migrateCache moves the cache and its backups from where older versions kept
+// them by default: the directory hbt was started from, usually the home
+// directory of the shell which started it, or that of the binary. It does
+// nothing if the cache directory is configured or already holds a cache, and
+// returns the directory the cache was moved from, if any.
+func migrateCache() (string, error) {
+	if configSources["cache"].kind != sourceDefault {
+		return "", nil
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		return "", nil
+	}
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+	if binary, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(binary))
+	}
+	for _, dir := range dirs {
+		legacy := filepath.Join(dir, internal.CacheName)
+		if _, err := os.Stat(legacy); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(internal.CachePath, 0o700); err != nil {
+			return "", err
+		}
+		if err := cache.Move(legacy, cachePath); err != nil {
+			return "", err
+		}
+		slog.Info("Moved cache", "from", legacy, "to", cachePath)
+		return dir, nil
+	}
+	return "", nil
+}
+
+// migrateSyncState moves the sync state, which used to be next to the cache,
+// to the state directory.
+func migrateSyncState(dirs []string) error {
+	syncPath := path.Join(internal.StateDir, internal.SyncStateName)
+	if _, err := os.Stat(syncPath); !os.IsNotExist(err) {
+		return nil
+	}
+	for _, dir := range dirs {
+		legacy := filepath.Join(dir, internal.LegacySyncStateName)
+		if _, err := os.Stat(legacy); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(internal.StateDir, 0o700); err != nil {
+			return err
+		}
+		if err := cache.Move(legacy, syncPath); err != nil {
+			return err
+		}
+		slog.Info("Moved sync state", "from", legacy, "to", syncPath)
+		return nil
+	}
+	return nil
+}
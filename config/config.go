@@ -0,0 +1,128 @@
+// Package config reads the configuration file of hbt.
+//
+// The file is written in TOML (https://toml.io). Keys are joined to the tables
+// they belong to with "-", and "_" is read as "-", so that
+//
+//	[prune]
+//	max_age = "720h"
+//
+// sets prune-max-age. Values are strings, integers, floats, booleans or arrays
+// of those.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lzambarda/hbt/xdg"
+)
+
+// Setting is a key of the configuration file along with its value.
+type Setting struct {
+	Key string
+	// A single value, unless Array is true. Values are kept as written, minus
+	// the quotes and escapes of strings.
+	Values []string
+	Array  bool
+}
+
+// DefaultPath returns where the configuration file is looked for by default,
+// config.toml in xdg.ConfigDir.
+func DefaultPath() string {
+	return filepath.Join(xdg.ConfigDir(), "config.toml")
+}
+
+// Load reads the configuration file at filePath.
+func Load(filePath string) ([]Setting, error) {
+	b, err := os.ReadFile(filePath) //nolint:gosec // It is okay.
+	if err != nil {
+		return nil, err
+	}
+	settings, err := Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return settings, nil
+}
+
+// Parse returns the settings of a configuration file, in the order they are
+// written.
+func Parse(s string) ([]Setting, error) {
+	var tree map[string]interface{}
+	md, err := toml.Decode(s, &tree)
+	if err != nil {
+		return nil, err
+	}
+	var settings []Setting
+	for _, key := range md.Keys() {
+		v := lookup(tree, key)
+		// Tables only hold other keys.
+		if _, ok := v.(map[string]interface{}); ok {
+			continue
+		}
+		setting := Setting{Key: name(key)}
+		if a, ok := v.([]interface{}); ok {
+			setting.Array = true
+			setting.Values = make([]string, 0, len(a))
+			for _, e := range a {
+				value, err := scalar(e)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", setting.Key, err)
+				}
+				setting.Values = append(setting.Values, value)
+			}
+		} else {
+			value, err := scalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", setting.Key, err)
+			}
+			setting.Values = []string{value}
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+func lookup(tree map[string]interface{}, key toml.Key) interface{} {
+	var v interface{} = tree
+	for _, part := range key {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+func name(key toml.Key) string {
+	parts := make([]string, len(key))
+	for i, part := range key {
+		parts[i] = strings.ReplaceAll(part, "_", "-")
+	}
+	return strings.Join(parts, "-")
+}
+
+func scalar(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		return "", errors.New("nested arrays are not supported")
+	case []map[string]interface{}, map[string]interface{}:
+		return "", errors.New("arrays of tables are not supported")
+	default:
+		return "", fmt.Errorf("unsupported value %v, strings must be quoted", v)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	settings, err := Parse(`# hbt
+port = "43112" # inline comment
+debug = true
+save_interval = '5m'
+
+[graph]
+max-history = 1_000
+implementation = "naive"
+
+[ignore]
+command = [
+	"ls",   # trailing commas are fine
+	"re:^rm \"-rf\"\t\u00e9",
+]
+dir = []
+
+[ "prune" . interval ]
+x = +3.5
+
+[sync]
+dir = "/sync"
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []Setting{
+		{Key: "port", Values: []string{"43112"}},
+		{Key: "debug", Values: []string{"true"}},
+		{Key: "save-interval", Values: []string{"5m"}},
+		{Key: "graph-max-history", Values: []string{"1000"}},
+		{Key: "graph-implementation", Values: []string{"naive"}},
+		{Key: "ignore-command", Values: []string{"ls", "re:^rm \"-rf\"\té"}, Array: true},
+		{Key: "ignore-dir", Values: []string{}, Array: true},
+		{Key: "prune-interval-x", Values: []string{"3.5"}},
+		{Key: "sync-dir", Values: []string{"/sync"}},
+	}, settings)
+
+	errs := map[string]string{
+		"port = 43111 43112":       "expected a top-level item to end",
+		"\nport = localhost":       "line 2",
+		"a = 1\na = 2":             "has already been defined",
+		"a = [[1]]":                "a: nested arrays are not supported",
+		"a = [{ b = 1 }]":          "a: arrays of tables are not supported",
+		"[[a]]\nb = 1":             "a: arrays of tables are not supported",
+		"a = 1979-05-27T07:32:00Z": "a: unsupported value",
+	}
+	for s, expected := range errs {
+		_, err = Parse(s)
+		if assert.Error(t, err, s) {
+			assert.Contains(t, err.Error(), expected, s)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	_, err := Load(filePath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(filePath, []byte("port = \"1\"\nport = \"2\"\n"), 0o600))
+	_, err = Load(filePath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filePath+": ")
+	assert.Contains(t, err.Error(), "line 2")
+
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	assert.Equal(t, "/xdg/hbt/config.toml", DefaultPath())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "/home/me")
+	assert.Equal(t, "/home/me/.config/hbt/config.toml", DefaultPath())
+}
@@ -0,0 +1,124 @@
+// Package daemon makes sure that a single server owns a cache.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrRunning is returned when another server already owns the cache.
+var ErrRunning = errors.New("hbt is already running")
+
+// Lock is held by the running server for as long as it runs, it makes sure
+// that it is the only one using the cache. The lock file holds the pid of the
+// server too. A lock file left behind by a server which crashed is not locked,
+// and is therefore not mistaken for a running server.
+type Lock struct {
+	f       *os.File
+	pidPath string
+}
+
+// Acquire locks the file at lockPath, which must be next to the cache, and
+// writes the pid of the current process to it, as well as to the pid file at
+// pidPath unless it is empty. The pid file is only meant to be read by other
+// tools, it is not locked. If another process holds the lock, the returned
+// error wraps ErrRunning.
+func Acquire(lockPath, pidPath string) (*Lock, error) {
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600) //nolint:gosec // It is okay.
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid, _ := readPid(f)
+		f.Close() //nolint:errcheck,gosec // It is okay.
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w with pid %d", ErrRunning, pid)
+		}
+		return nil, err
+	}
+	if err = f.Truncate(0); err != nil {
+		f.Close() //nolint:errcheck,gosec // It is okay.
+		return nil, err
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()) + "\n")
+	if _, err = f.WriteAt(pid, 0); err != nil {
+		f.Close() //nolint:errcheck,gosec // It is okay.
+		return nil, err
+	}
+	if pidPath != "" {
+		// Only the owner of the lock writes it, so it is replaced at once.
+		if err = os.WriteFile(pidPath, pid, 0o600); err != nil {
+			f.Close() //nolint:errcheck,gosec // It is okay.
+			return nil, err
+		}
+	}
+	return &Lock{f: f, pidPath: pidPath}, nil
+}
+
+// Release removes the pid file, unless another server replaced it, then
+// empties and unlocks the lock file. The lock file is not removed, as another
+// server might be about to lock it.
+func (l *Lock) Release() error {
+	if l.pidPath != "" {
+		if b, err := os.ReadFile(l.pidPath); err == nil && strings.TrimSpace(string(b)) == strconv.Itoa(os.Getpid()) {
+			os.Remove(l.pidPath) //nolint:errcheck,gosec // It is okay.
+		}
+	}
+	if err := l.f.Truncate(0); err != nil {
+		l.f.Close() //nolint:errcheck,gosec // It is okay.
+		return err
+	}
+	return l.f.Close()
+}
+
+// Running tells whether a server holds the lock file at lockPath, returning
+// its pid too. The pid is 0 if the server has just started and has not written
+// it yet.
+func Running(lockPath string) (pid int, running bool, err error) {
+	f, err := os.Open(lockPath) //nolint:gosec // It is okay.
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer f.Close() //nolint:errcheck // It is okay.
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		return 0, false, syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}
+	if !errors.Is(err, syscall.EWOULDBLOCK) {
+		return 0, false, err
+	}
+	pid, err = readPid(f)
+	return pid, true, err
+}
+
+// readPid returns 0 if f is empty.
+func readPid(f *os.File) (int, error) {
+	b, err := io.ReadAll(io.NewSectionReader(f, 0, 32))
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// Spawn starts binary with args in the background, detached from the
+// terminal and the session of the caller.
+func Spawn(binary string, args []string) (*exec.Cmd, error) {
+	cmd := exec.Command(binary, args...) //nolint:gosec // It is okay.
+	// Do not keep the directory of the caller busy.
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return cmd, cmd.Start()
+}
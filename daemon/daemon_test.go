@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon(t *testing.T) {
+	dir := t.TempDir()
+	filePath := path.Join(dir, "lock")
+	pidPath := path.Join(dir, "pid")
+	_, running, err := Running(filePath)
+	require.NoError(t, err)
+	assert.False(t, running, "no lock file")
+
+	l, err := Acquire(filePath, pidPath)
+	require.NoError(t, err)
+	pid, running, err := Running(filePath)
+	require.NoError(t, err)
+	assert.True(t, running)
+	assert.Equal(t, os.Getpid(), pid)
+	b, err := os.ReadFile(pidPath)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintln(os.Getpid()), string(b))
+
+	// Locks are held by open files, so a second one conflicts even within the
+	// same process, whatever its pid file.
+	_, err = Acquire(filePath, path.Join(dir, "other"))
+	assert.True(t, errors.Is(err, ErrRunning))
+	assert.Contains(t, err.Error(), "pid")
+	assert.NoFileExists(t, path.Join(dir, "other"))
+
+	require.NoError(t, l.Release())
+	_, running, err = Running(filePath)
+	require.NoError(t, err)
+	assert.False(t, running, "stale lock file")
+	assert.NoFileExists(t, pidPath)
+
+	l, err = Acquire(filePath, "")
+	require.NoError(t, err)
+	require.NoError(t, l.Release())
+}
@@ -1,8 +1,9 @@
 module github.com/lzambarda/hbt
 
-go 1.17
+go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
 )
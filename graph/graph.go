@@ -0,0 +1,116 @@
+// Package graph contains the types shared by the suggestion graph
+// implementations and their users.
+package graph
+
+import (
+	"fmt"
+	"time"
+)
+
+// PruneOptions are the rules deciding which knowledge is stale. The zero value
+// of each rule disables it.
+//
+//nolint:govet // Prefer this order of readability.
+type PruneOptions struct {
+	// Commands not used for longer than this are removed.
+	MaxAge time.Duration
+	// Commands with fewer hits are removed once they have not been used for
+	// MinHitsGrace, so that new commands get a chance to be repeated.
+	MinHits      int
+	MinHitsGrace time.Duration
+	// Only the commands with the most hits are kept in each directory.
+	MaxEdgesPerNode int
+	// Only the most recently used directories are kept.
+	MaxNodes int
+	// Report what would be removed without removing it.
+	DryRun bool
+}
+
+// PruneResult reports what a prune removed.
+type PruneResult struct {
+	Nodes int
+	Edges int
+}
+
+func (r PruneResult) String() string {
+	return fmt.Sprintf("pruned %d directories and %d commands", r.Nodes, r.Edges)
+}
+
+// Forgotten is a command removed by a forget.
+type Forgotten struct {
+	Wd   string
+	Cmd  string
+	Hits int
+}
+
+// Step is a command run by a session, in a directory.
+type Step struct {
+	Wd  string
+	Cmd string
+}
+
+// SessionState is what a graph knows about a session.
+type SessionState struct {
+	ID string
+	// The most recent commands, the most recent first.
+	History []Step
+	// How many times the session asked for a hint since its last command,
+	// which is used to cycle through the suggestions.
+	Cursor int
+}
+
+// Candidate is a command which can be suggested in a directory.
+type Candidate struct {
+	Cmd   string
+	Score int
+	// The filter rules now matching the command, which was tracked before
+	// they were configured.
+	Filters []string
+}
+
+// Explanation describes how a hint is chosen.
+type Explanation struct {
+	// The directory a hint was asked for.
+	Wd string
+	// The directory whose commands are suggested, empty if none matched.
+	Matched string
+	// The trailing path components of Wd which matched, when no node exists
+	// for Wd itself.
+	Components []string
+	// Sorted by descending score, then by command.
+	Candidates []Candidate
+	// The suggestion cursor of the session, and the index of the candidate
+	// which would be suggested next.
+	Cursor, Next int
+}
+
+// Exact returns true if Wd itself is known.
+func (e Explanation) Exact() bool {
+	return e.Matched != "" && e.Matched == e.Wd
+}
+
+// CommandStats counts the hits of a command.
+type CommandStats struct {
+	Cmd  string
+	Hits int
+}
+
+// DirectoryStats describes what is known about a directory.
+type DirectoryStats struct {
+	Wd       string
+	Hits     int
+	Commands int
+	// The most used commands, the most used first.
+	Top []CommandStats
+}
+
+// Stats describes how much a graph has learned.
+type Stats struct {
+	Directories int
+	Commands    int
+	Hits        int
+	// The most used commands, adding up their hits in every directory.
+	TopCommands []CommandStats
+	// The directories with the most hits.
+	TopDirectories []DirectoryStats
+}
@@ -0,0 +1,67 @@
+package naive
+
+import "github.com/lzambarda/hbt/cache"
+
+// RecordDelta makes the graph remember what is tracked from now on, so that
+// it can be exported with ExportDelta and shared with other hosts.
+func (g *Graph) RecordDelta() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.delta == nil {
+		g.delta = g.newDelta()
+	}
+}
+
+func (g *Graph) newDelta() *Graph {
+	d := NewGraph(g.MaxWalkerHistory, g.MinCommonPath)
+	d.Encoding = g.Encoding
+	d.Compress = g.Compress
+	return d
+}
+
+// ExportDelta saves what has been tracked since the previous export (or since
+// RecordDelta) to filePath and starts recording a new delta.
+// Nothing is written if nothing has been tracked, in which case false is
+// returned. If the delta cannot be written, it is kept for the next export.
+func (g *Graph) ExportDelta(filePath string) (bool, error) {
+	g.mu.Lock()
+	d := g.delta
+	if d == nil || len(d.Nodes) == 0 {
+		g.mu.Unlock()
+		return false, nil
+	}
+	// Sessions start over in the new delta, so the link between the last
+	// command exported and the next one is only known locally.
+	g.delta = g.newDelta()
+	g.mu.Unlock()
+
+	d.mu.Lock()
+	sg := d.serialisable()
+	d.mu.Unlock()
+	b, err := encode(sg, d.Encoding, d.Compress)
+	if err == nil {
+		err = cache.WriteAtomic(filePath, b)
+	}
+	if err != nil {
+		g.mu.Lock()
+		if g.delta != nil {
+			g.delta.Merge(d, MergeOptions{Strategy: MergeSum})
+		}
+		g.mu.Unlock()
+		return false, err
+	}
+	return true, nil
+}
+
+// ImportDelta adds the hits of a delta exported by another graph. Imported
+// hits are not part of the delta of g, so that they are not exported back.
+func (g *Graph) ImportDelta(filePath string) error {
+	g.mu.Lock()
+	d := g.newDelta()
+	g.mu.Unlock()
+	if err := d.LoadStrict(filePath); err != nil {
+		return err
+	}
+	g.Merge(d, MergeOptions{Strategy: MergeSum})
+	return nil
+}
@@ -0,0 +1,337 @@
+package naive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Encoding identifies the format used to serialise the graph.
+type Encoding string
+
+const (
+	// EncodingJSON is the human readable format. It is the default one.
+	EncodingJSON Encoding = "json"
+	// EncodingBinary is a compact varint based format where repeated strings
+	// (directories and commands) are interned and stored only once.
+	EncodingBinary Encoding = "binary"
+)
+
+// ErrUnknownEncoding is returned when parsing an unsupported encoding name.
+var ErrUnknownEncoding = errors.New("unknown encoding")
+
+// ParseEncoding returns the Encoding matching the given name.
+func ParseEncoding(name string) (Encoding, error) {
+	switch e := Encoding(name); e {
+	case EncodingJSON, EncodingBinary:
+		return e, nil
+	case "":
+		return EncodingJSON, nil
+	default:
+		return "", fmt.Errorf("%q: %w", name, ErrUnknownEncoding)
+	}
+}
+
+// binaryMagic prefixes every file written with EncodingBinary, followed by a
+// single version byte.
+var binaryMagic = []byte("HBTB")
+
+// Version 2 appends the checksum of the graph and version 3 adds the last
+// time edges were used. Older versions are still accepted.
+const binaryVersion = 3
+
+// gzipMagic is the header of any gzip stream (RFC 1952).
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// encode serialises sg with the given encoding, optionally compressing it.
+func encode(sg *serialisableGraph, enc Encoding, compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	var err error
+	switch enc {
+	case EncodingBinary:
+		err = encodeBinary(w, sg)
+	case EncodingJSON, "":
+		err = json.NewEncoder(w).Encode(sg)
+	default:
+		err = fmt.Errorf("%q: %w", enc, ErrUnknownEncoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if zw != nil {
+		if err = zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// decode is the opposite of encode. The encoding and compression are
+// detected by looking at the first bytes of b.
+func decode(b []byte) (*serialisableGraph, error) {
+	if bytes.HasPrefix(b, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close() //nolint:errcheck // Read only.
+		b, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sg := &serialisableGraph{}
+	if bytes.HasPrefix(b, binaryMagic) {
+		return sg, decodeBinary(b[len(binaryMagic):], sg)
+	}
+	return sg, json.Unmarshal(b, sg)
+}
+
+func encodeBinary(w io.Writer, sg *serialisableGraph) error {
+	bw := bufio.NewWriter(w)
+	// Intern every string first, so that each edge only needs an index.
+	// Commands are sorted to make the output deterministic.
+	index := map[string]uint64{}
+	strs := make([]string, 0, len(sg.Wds))
+	intern := func(s string) uint64 {
+		i, ok := index[s]
+		if !ok {
+			i = uint64(len(strs))
+			index[s] = i
+			strs = append(strs, s)
+		}
+		return i
+	}
+	cmds := make([][]string, len(sg.Edges))
+	for _, wd := range sg.Wds {
+		intern(wd)
+	}
+	for i, edges := range sg.Edges {
+		cmds[i] = make([]string, 0, len(edges))
+		for cmd := range edges {
+			cmds[i] = append(cmds[i], cmd)
+		}
+		sort.Strings(cmds[i])
+		for _, cmd := range cmds[i] {
+			intern(cmd)
+		}
+	}
+
+	tmp := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(tmp, v)
+		bw.Write(tmp[:n]) //nolint:errcheck,gosec // Checked on flush.
+	}
+	putVarint := func(v int64) {
+		n := binary.PutVarint(tmp, v)
+		bw.Write(tmp[:n]) //nolint:errcheck,gosec // Checked on flush.
+	}
+
+	bw.Write(binaryMagic)       //nolint:errcheck,gosec // Checked on flush.
+	bw.WriteByte(binaryVersion) //nolint:errcheck,gosec // Checked on flush.
+	putUvarint(uint64(len(strs)))
+	for _, s := range strs {
+		putUvarint(uint64(len(s)))
+		bw.WriteString(s) //nolint:errcheck,gosec // Checked on flush.
+	}
+	putUvarint(uint64(len(sg.Wds)))
+	for i, wd := range sg.Wds {
+		putUvarint(index[wd])
+		putUvarint(uint64(len(cmds[i])))
+		for _, cmd := range cmds[i] {
+			se := sg.Edges[i][cmd]
+			putUvarint(index[cmd])
+			putUvarint(uint64(se.Hits))
+			putVarint(int64(se.To))
+			putVarint(se.LastUsed)
+		}
+	}
+	var sum uint32
+	if sg.Sum != nil {
+		sum = *sg.Sum
+	} else {
+		sum = sg.checksum()
+	}
+	binary.BigEndian.PutUint32(tmp, sum)
+	bw.Write(tmp[:4]) //nolint:errcheck,gosec // Checked on flush.
+	return bw.Flush()
+}
+
+// ErrMalformedBinary is returned when a binary encoded graph cannot be read.
+var ErrMalformedBinary = errors.New("malformed binary graph")
+
+func decodeBinary(b []byte, sg *serialisableGraph) error {
+	if len(b) == 0 || b[0] == 0 || b[0] > binaryVersion {
+		return fmt.Errorf("%w: unsupported version", ErrMalformedBinary)
+	}
+	version := b[0]
+	b = b[1:]
+	var err error
+	uvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			err = fmt.Errorf("%w: bad varint", ErrMalformedBinary)
+			return 0
+		}
+		b = b[n:]
+		return v
+	}
+	varint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			err = fmt.Errorf("%w: bad varint", ErrMalformedBinary)
+			return 0
+		}
+		b = b[n:]
+		return v
+	}
+	// Every count is checked against the remaining bytes so that a corrupt
+	// file cannot make us allocate absurd amounts of memory.
+	count := func() int {
+		v := uvarint()
+		if err == nil && v > uint64(len(b)) {
+			err = fmt.Errorf("%w: count out of range", ErrMalformedBinary)
+			return 0
+		}
+		return int(v)
+	}
+	str := func(strs []string) string {
+		i := uvarint()
+		if err == nil && i >= uint64(len(strs)) {
+			err = fmt.Errorf("%w: string index out of range", ErrMalformedBinary)
+			return ""
+		}
+		if err != nil {
+			return ""
+		}
+		return strs[i]
+	}
+
+	strs := make([]string, count())
+	for i := range strs {
+		l := count()
+		if err != nil {
+			return err
+		}
+		strs[i] = string(b[:l])
+		b = b[l:]
+	}
+	nodes := count()
+	sg.Wds = make([]string, 0, nodes)
+	sg.Edges = make([]map[string]serialisableEdge, 0, nodes)
+	for i := 0; i < nodes && err == nil; i++ {
+		sg.Wds = append(sg.Wds, str(strs))
+		n := count()
+		edges := make(map[string]serialisableEdge, n)
+		for j := 0; j < n && err == nil; j++ {
+			cmd := str(strs)
+			se := serialisableEdge{
+				Hits: int(uvarint()),
+				To:   int(varint()),
+			}
+			if version >= 3 {
+				se.LastUsed = varint()
+			}
+			edges[cmd] = se
+		}
+		sg.Edges = append(sg.Edges, edges)
+	}
+	if err != nil {
+		return err
+	}
+	if version >= 2 {
+		if len(b) < 4 {
+			return fmt.Errorf("%w: missing checksum", ErrMalformedBinary)
+		}
+		sum := binary.BigEndian.Uint32(b)
+		sg.Sum = &sum
+		b = b[4:]
+	}
+	if len(b) != 0 {
+		return fmt.Errorf("%w: trailing data", ErrMalformedBinary)
+	}
+	return nil
+}
+
+// ErrCorrupt is returned when a decoded graph is not consistent.
+var ErrCorrupt = errors.New("corrupt graph")
+
+// checksum returns a CRC-32 of the content of sg. It does not depend on the
+// encoding nor on the iteration order of the edge maps.
+func (sg *serialisableGraph) checksum() uint32 {
+	h := crc32.NewIEEE()
+	tmp := make([]byte, binary.MaxVarintLen64)
+	for i, wd := range sg.Wds {
+		h.Write([]byte(wd)) //nolint:errcheck,gosec // Never fails.
+		h.Write([]byte{0})  //nolint:errcheck,gosec // Never fails.
+		if i >= len(sg.Edges) {
+			continue
+		}
+		cmds := make([]string, 0, len(sg.Edges[i]))
+		for cmd := range sg.Edges[i] {
+			cmds = append(cmds, cmd)
+		}
+		sort.Strings(cmds)
+		for _, cmd := range cmds {
+			se := sg.Edges[i][cmd]
+			h.Write([]byte(cmd)) //nolint:errcheck,gosec // Never fails.
+			h.Write([]byte{0})   //nolint:errcheck,gosec // Never fails.
+			n := binary.PutVarint(tmp, int64(se.Hits))
+			h.Write(tmp[:n]) //nolint:errcheck,gosec // Never fails.
+			n = binary.PutVarint(tmp, int64(se.To))
+			h.Write(tmp[:n]) //nolint:errcheck,gosec // Never fails.
+			// Only when known, to match the checksums of older versions.
+			if se.LastUsed != 0 {
+				n = binary.PutVarint(tmp, se.LastUsed)
+				h.Write(tmp[:n]) //nolint:errcheck,gosec // Never fails.
+			}
+		}
+	}
+	return h.Sum32()
+}
+
+// validate checks that sg can be safely turned into a Graph.
+func (sg *serialisableGraph) validate() error {
+	if sg.Sum != nil {
+		if sum := sg.checksum(); sum != *sg.Sum {
+			return fmt.Errorf("%w: checksum mismatch, expected %08x, got %08x", ErrCorrupt, *sg.Sum, sum)
+		}
+	}
+	if len(sg.Wds) != len(sg.Edges) {
+		return fmt.Errorf("%w: %d directories but %d edge sets", ErrCorrupt, len(sg.Wds), len(sg.Edges))
+	}
+	seen := make(map[string]struct{}, len(sg.Wds))
+	for _, wd := range sg.Wds {
+		if _, ok := seen[wd]; ok {
+			return fmt.Errorf("%w: duplicate directory %q", ErrCorrupt, wd)
+		}
+		seen[wd] = struct{}{}
+	}
+	for i, edges := range sg.Edges {
+		for cmd, se := range edges {
+			if se.To < -1 || se.To >= len(sg.Wds) {
+				return fmt.Errorf("%w: edge %q of %q points to unknown node %d", ErrCorrupt, cmd, sg.Wds[i], se.To)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package naive
+
+import (
+	"sort"
+
+	"github.com/lzambarda/hbt/cache"
+	"github.com/lzambarda/hbt/graph"
+)
+
+// Forget removes the commands for which match returns true, in every
+// directory, including the delta recorded for synchronisation. The removed
+// commands are returned sorted by directory and command. With dryRun nothing
+// is removed.
+func (g *Graph) Forget(match func(cmd string) bool, dryRun bool) []graph.Forgotten {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.delta != nil {
+		g.delta.Forget(match, dryRun)
+	}
+	return g.forget(match, dryRun)
+}
+
+func (g *Graph) forget(match func(cmd string) bool, dryRun bool) []graph.Forgotten {
+	var forgotten []graph.Forgotten
+	for wd, n := range g.Nodes {
+		for cmd, e := range n.edges {
+			if !match(cmd) {
+				continue
+			}
+			forgotten = append(forgotten, graph.Forgotten{
+				Wd:   wd,
+				Cmd:  cmd,
+				Hits: e.Hits,
+			})
+			if !dryRun {
+				delete(n.edges, cmd)
+			}
+		}
+	}
+	if len(forgotten) > 0 && !dryRun {
+		// The suggestion offsets are invalidated, see Delete.
+		for id := range g.suggestionState {
+			g.suggestionState[id] = 0
+		}
+	}
+	sort.Slice(forgotten, func(i, j int) bool {
+		if forgotten[i].Wd != forgotten[j].Wd {
+			return forgotten[i].Wd < forgotten[j].Wd
+		}
+		return forgotten[i].Cmd < forgotten[j].Cmd
+	})
+	return forgotten
+}
+
+// ForgetFile is like Forget, but on the graph saved at filePath, e.g. a
+// backup. The file is rewritten in place, using the encoding settings of g.
+func (g *Graph) ForgetFile(filePath string, match func(cmd string) bool, dryRun bool) ([]graph.Forgotten, error) {
+	saved := NewGraph(g.MaxWalkerHistory, g.MinCommonPath)
+	if err := saved.LoadStrict(filePath); err != nil {
+		return nil, err
+	}
+	forgotten := saved.forget(match, dryRun)
+	if len(forgotten) == 0 || dryRun {
+		return forgotten, nil
+	}
+	b, err := encode(saved.serialisable(), g.Encoding, g.Compress)
+	if err != nil {
+		return nil, err
+	}
+	return forgotten, cache.WriteAtomic(filePath, b)
+}
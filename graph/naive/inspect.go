@@ -0,0 +1,97 @@
+package naive
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/lzambarda/hbt/graph"
+)
+
+// Sessions returns the state of every session known to the graph, sorted by
+// id.
+func (g *Graph) Sessions() []graph.SessionState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	wds := g.wds()
+	ids := map[string]struct{}{}
+	for id := range g.walkers {
+		ids[id] = struct{}{}
+	}
+	for id := range g.suggestionState {
+		ids[id] = struct{}{}
+	}
+	sessions := make([]graph.SessionState, 0, len(ids))
+	for id := range ids {
+		sessions = append(sessions, g.session(id, wds))
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+// Session returns the state of session id, or false if the graph does not
+// know about it.
+func (g *Graph) Session(id string) (graph.SessionState, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, walking := g.walkers[id]
+	_, hinting := g.suggestionState[id]
+	if !walking && !hinting {
+		return graph.SessionState{}, false
+	}
+	return g.session(id, g.wds()), true
+}
+
+// wds returns the directory of each node, as nodes do not know it.
+func (g *Graph) wds() map[*node]string {
+	wds := make(map[*node]string, len(g.Nodes))
+	for wd, n := range g.Nodes {
+		wds[n] = wd
+	}
+	return wds
+}
+
+func (g *Graph) session(id string, wds map[*node]string) graph.SessionState {
+	state := graph.SessionState{
+		ID:     id,
+		Cursor: g.suggestionState[id],
+	}
+	for _, wn := range g.walkers[id] {
+		step := graph.Step{Wd: wds[wn.lastNode]}
+		for cmd, e := range wn.lastNode.edges {
+			if e == wn.lastEdge {
+				step.Cmd = cmd
+				break
+			}
+		}
+		state.History = append(state.History, step)
+	}
+	return state
+}
+
+// Explain describes what Hint would suggest to session id in wd, without
+// moving its suggestion cursor.
+func (g *Graph) Explain(id, wd string) graph.Explanation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := graph.Explanation{
+		Wd:     wd,
+		Cursor: g.suggestionState[id],
+	}
+	n, matched := g.lookup(wd)
+	if n == nil {
+		return e
+	}
+	e.Matched = matched
+	if matched != wd {
+		e.Components = strings.Split(strings.TrimPrefix(matched, "/"), "/")
+	}
+	for _, c := range n.getSortedEdges() {
+		e.Candidates = append(e.Candidates, graph.Candidate{Cmd: c.cmd, Score: c.score})
+	}
+	if len(e.Candidates) > 0 {
+		e.Next = e.Cursor % len(e.Candidates)
+	}
+	return e
+}
@@ -0,0 +1,140 @@
+package naive
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MergeStrategy defines how the hits of an edge known by both graphs are
+// combined.
+type MergeStrategy string
+
+const (
+	// MergeSum adds the hits together. Use it when the graphs have learned
+	// from different histories.
+	MergeSum MergeStrategy = "sum"
+	// MergeMax keeps the highest hits. Use it when the graphs might share
+	// part of their history, e.g. one is a copy of the other.
+	MergeMax MergeStrategy = "max"
+)
+
+// ErrUnknownMergeStrategy is returned when parsing an unsupported strategy.
+var ErrUnknownMergeStrategy = errors.New("unknown merge strategy")
+
+// ParseMergeStrategy returns the MergeStrategy matching the given name.
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	switch s := MergeStrategy(name); s {
+	case MergeSum, MergeMax:
+		return s, nil
+	case "":
+		return MergeSum, nil
+	default:
+		return "", fmt.Errorf("%q: %w", name, ErrUnknownMergeStrategy)
+	}
+}
+
+// Remap rewrites directories starting with From so that they start with To
+// instead, e.g. /Users/x -> /home/x.
+type Remap struct {
+	From string
+	To   string
+}
+
+// ParseRemap parses a remap in the from=to form.
+func ParseRemap(s string) (Remap, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Remap{}, fmt.Errorf("%q: remap must be in the from=to form", s)
+	}
+	return Remap{
+		From: strings.TrimSuffix(parts[0], "/"),
+		To:   strings.TrimSuffix(parts[1], "/"),
+	}, nil
+}
+
+// MergeOptions configures Graph.Merge.
+type MergeOptions struct {
+	Strategy MergeStrategy
+	// Remaps are applied to the directories of the merged graph. Only whole
+	// path components are matched and the longest matching From wins.
+	Remaps []Remap
+}
+
+func (o *MergeOptions) remap(wd string) string {
+	best := -1
+	for i, r := range o.Remaps {
+		if wd != r.From && !strings.HasPrefix(wd, r.From+"/") {
+			continue
+		}
+		if best == -1 || len(r.From) > len(o.Remaps[best].From) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return wd
+	}
+	return o.Remaps[best].To + strings.TrimPrefix(wd, o.Remaps[best].From)
+}
+
+// Merge adds the knowledge of other to g. Nodes are matched by directory,
+// after applying the remaps, and edges by command. Hits are combined
+// according to the strategy, while the destination of an edge known by both
+// graphs is taken from the one with the most hits.
+// Sessions are not merged and other is left untouched.
+func (g *Graph) Merge(other *Graph, opts MergeOptions) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.merge(other, opts)
+}
+
+func (g *Graph) merge(other *Graph, opts MergeOptions) {
+	// Directories are sorted so that new node ids do not depend on the map
+	// iteration order.
+	wds := make([]string, 0, len(other.Nodes))
+	for wd := range other.Nodes {
+		wds = append(wds, wd)
+	}
+	sort.Strings(wds)
+	// First pass, find or create the matching nodes.
+	nodes := make(map[*node]*node, len(other.Nodes))
+	for _, wd := range wds {
+		mapped := opts.remap(wd)
+		n, ok := g.Nodes[mapped]
+		if !ok {
+			n = &node{
+				id:    len(g.Nodes),
+				edges: map[string]*edge{},
+			}
+			g.Nodes[mapped] = n
+		}
+		nodes[other.Nodes[wd]] = n
+	}
+	// Second pass, combine the edges now that every To can be resolved.
+	for _, wd := range wds {
+		on := other.Nodes[wd]
+		n := nodes[on]
+		for cmd, oe := range on.edges {
+			e, ok := n.edges[cmd]
+			if !ok {
+				e = &edge{From: n}
+				n.edges[cmd] = e
+			}
+			if oe.To != nil && (e.To == nil || oe.Hits > e.Hits) {
+				e.To = nodes[oe.To]
+			}
+			if oe.LastUsed > e.LastUsed {
+				e.LastUsed = oe.LastUsed
+			}
+			switch opts.Strategy {
+			case MergeMax:
+				if oe.Hits > e.Hits {
+					e.Hits = oe.Hits
+				}
+			default:
+				e.Hits += oe.Hits
+			}
+		}
+	}
+}
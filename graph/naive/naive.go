@@ -5,14 +5,16 @@
 package naive
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"path"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/lzambarda/hbt/internal"
+	"github.com/lzambarda/hbt/cache"
 )
 
 //nolint:govet // Prefer this order of memory efficiency.
@@ -20,6 +22,8 @@ type edge struct {
 	Hits int   `json:"c"`
 	From *node `json:"f"`
 	To   *node `json:"t"`
+	// Unix time of the last Track of this edge.
+	LastUsed int64 `json:"u"`
 }
 
 // cmd -> node.
@@ -44,8 +48,13 @@ func (n *node) getSortedEdges() []*cmdEdge {
 	for cmd, e := range n.edges {
 		sorted = append(sorted, &cmdEdge{cmd, e.Hits})
 	}
+	// Ties are broken by command, so that cycling through the suggestions
+	// does not depend on the map order.
 	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].score > sorted[j].score
+		if sorted[i].score != sorted[j].score {
+			return sorted[i].score > sorted[j].score
+		}
+		return sorted[i].cmd < sorted[j].cmd
 	})
 	return sorted
 }
@@ -100,6 +109,15 @@ type Graph struct {
 	// For each session, keep an internal counter to cycle through the possible
 	// suggestions.
 	suggestionState map[string]int
+	// Encoding used by Save. Load detects it on its own.
+	Encoding Encoding `json:"-"`
+	// Whether Save should gzip the serialised graph.
+	Compress bool `json:"-"`
+	// What has been tracked since the last ExportDelta, nil unless
+	// RecordDelta has been called.
+	delta *Graph
+	// Graphs are accessed by concurrent connections and background jobs.
+	mu sync.Mutex
 }
 
 // NewGraph returns usable Graph instances.
@@ -114,18 +132,31 @@ func NewGraph(maxWalkerHistory, minCommonPath int) *Graph {
 		MinCommonPath:    minCommonPath,
 		walkers:          map[string]walker{},
 		suggestionState:  map[string]int{},
+		Encoding:         EncodingJSON,
 	}
 }
 
+// Configure gives g the settings of other, e.g. a graph returned by NewGraph,
+// keeping what g has learned. Sessions in progress keep their history.
+func (g *Graph) Configure(other *Graph) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.MaxWalkerHistory = other.MaxWalkerHistory
+	g.MinCommonPath = other.MinCommonPath
+	g.Encoding = other.Encoding
+	g.Compress = other.Compress
+}
+
 func (g *Graph) newNode(wd, cmd string, parent *node) (*node, *edge) {
 	n := &node{
 		id:    len(g.Nodes), // this will eventually break
 		edges: map[string]*edge{},
 	}
 	e := &edge{
-		Hits: 1,
-		From: n,
-		To:   parent,
+		Hits:     1,
+		From:     n,
+		To:       parent,
+		LastUsed: now().Unix(),
 	}
 	n.edges[cmd] = e
 	g.Nodes[wd] = n
@@ -135,6 +166,11 @@ func (g *Graph) newNode(wd, cmd string, parent *node) (*node, *edge) {
 // Track adds to the graph the command cmd performed at path wd by the id
 // user/process.
 func (g *Graph) Track(id, wd, cmd string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.delta != nil {
+		g.delta.Track(id, wd, cmd)
+	}
 	// Check if this is a new session we are creating
 	walker := g.walkers[id]
 	if walker == nil {
@@ -158,9 +194,10 @@ func (g *Graph) Track(id, wd, cmd string) {
 		// TODO: should really check permutations of the command (maybe even
 		// just the binary name)
 		e := &edge{
-			Hits: 1,
-			From: n,
-			To:   nil,
+			Hits:     1,
+			From:     n,
+			To:       nil,
+			LastUsed: now().Unix(),
 		}
 		n.edges[cmd] = e
 		g.walkers[id] = walker.progress(&walkerNode{
@@ -170,6 +207,7 @@ func (g *Graph) Track(id, wd, cmd string) {
 		return
 	}
 	n.edges[cmd].Hits++
+	n.edges[cmd].LastUsed = now().Unix()
 	// Reference to itself
 	if len(walker) == 0 {
 		g.walkers[id] = walker.progress(&walkerNode{
@@ -183,23 +221,34 @@ func (g *Graph) Track(id, wd, cmd string) {
 
 const shrug = "¯\\_(ツ)_/¯"
 
+// now is replaced in tests.
+var now = time.Now
+
 func (g *Graph) findNode(wd string) *node {
+	n, _ := g.lookup(wd)
+	return n
+}
+
+// lookup is like findNode, also returning the directory of the node found.
+func (g *Graph) lookup(wd string) (*node, string) {
 	if n, ok := g.Nodes[wd]; ok {
-		return n
+		return n, wd
 	}
 	// Try to see if we have a node with a similar structure
 	wd = strings.TrimPrefix(wd, "/")
 	pathComponents := strings.Split(wd, "/")
 	if len(pathComponents) > g.MinCommonPath {
 		// Reduce the path to the common path and check again
-		return g.findNode("/" + path.Join(pathComponents[len(pathComponents)-g.MinCommonPath:]...))
+		return g.lookup("/" + path.Join(pathComponents[len(pathComponents)-g.MinCommonPath:]...))
 	}
 	// Maybe even check the walker's history
-	return nil
+	return nil, ""
 }
 
 // Hint returns the next suggestion for user/process id at path wd.
 func (g *Graph) Hint(id, wd string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	n := g.findNode(wd)
 	if n == nil {
 		// Reset suggestion for session
@@ -219,12 +268,13 @@ func (g *Graph) Hint(id, wd string) string {
 	}
 	sorted := n.getSortedEdges()
 	bestIndex := g.suggestionState[id] % len(n.edges)
-	if internal.Debug {
-		fmt.Println("Sorted Edges:")
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		candidates := make([]string, len(sorted))
 		for i, s := range sorted {
-			fmt.Printf("  [%d] %s\n", i, s)
+			candidates[i] = fmt.Sprint(s)
 		}
-		fmt.Printf("Best index %d %% %d = %d\n", g.suggestionState[id], len(n.edges), bestIndex)
+		slog.Debug("Hint candidates", "session", id, "wd", wd, "candidates", candidates,
+			"cursor", g.suggestionState[id], "index", bestIndex)
 	}
 	best := sorted[bestIndex].cmd
 	if best == "" {
@@ -239,6 +289,8 @@ func (g *Graph) Hint(id, wd string) string {
 // Delete removes a previously tracked command. It should not return an
 // error.
 func (g *Graph) Delete(id, wd, cmd string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	n := g.findNode(wd)
 	if n == nil {
 		return
@@ -255,7 +307,10 @@ func (g *Graph) Delete(id, wd, cmd string) {
 // End clears a session for user/process id. This is useful to reset a
 // stateful graph.
 func (g *Graph) End(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	delete(g.walkers, id)
+	delete(g.suggestionState, id)
 }
 
 // These structs are what we need to move from a programmer-friendly structure
@@ -264,17 +319,35 @@ func (g *Graph) End(id string) {
 type serialisableGraph struct {
 	Wds   []string                      `json:"wds"`
 	Edges []map[string]serialisableEdge `json:"edges"`
+	// Checksum of the fields above. Caches saved by older versions do not
+	// have one.
+	Sum *uint32 `json:"sum,omitempty"`
 }
 type serialisableEdge struct {
 	Hits int `json:"h"`
 	To   int `json:"t"`
+	// Missing in caches saved by older versions.
+	LastUsed int64 `json:"u,omitempty"`
 }
 
-// Save serialises the graph to the given file path.
+// Save serialises the graph to the given file path, using the graph Encoding
+// and compression settings.
 func (g *Graph) Save(filePath string) error {
-	// We first need to build a model which doesn't contain pointers nor cycles.
+	g.mu.Lock()
+	sg := g.serialisable()
+	enc, compress := g.Encoding, g.Compress
+	g.mu.Unlock()
+	b, err := encode(sg, enc, compress)
+	if err != nil {
+		return err
+	}
+	return cache.Write(filePath, b)
+}
+
+// serialisable builds a model which doesn't contain pointers nor cycles.
+func (g *Graph) serialisable() *serialisableGraph {
 	nodes := make([]*node, len(g.Nodes))
-	sg := serialisableGraph{
+	sg := &serialisableGraph{
 		Wds:   make([]string, len(g.Nodes)),
 		Edges: make([]map[string]serialisableEdge, len(g.Nodes)),
 	}
@@ -288,8 +361,9 @@ func (g *Graph) Save(filePath string) error {
 		sg.Edges[fromIndex] = map[string]serialisableEdge{}
 		for cmd, e := range n.edges {
 			se := serialisableEdge{
-				Hits: e.Hits,
-				To:   -1,
+				Hits:     e.Hits,
+				To:       -1,
+				LastUsed: e.LastUsed,
 			}
 			if e.To != nil {
 				se.To = e.To.id
@@ -297,31 +371,52 @@ func (g *Graph) Save(filePath string) error {
 			sg.Edges[fromIndex][cmd] = se
 		}
 	}
-	b, err := json.Marshal(sg)
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(filePath, b, os.ModePerm)
+	sum := sg.checksum()
+	sg.Sum = &sum
+	return sg
 }
 
 // Load initialises the graph with a serialiastion at the give file path.
+// The encoding and compression of the file are detected automatically.
+// A corrupt file is replaced by its most recent valid backup, or by an empty
+// graph if there is none.
 func (g *Graph) Load(filePath string) error {
-	b, err := os.ReadFile(filePath) //nolint:gosec // It is okay.
+	return cache.Read(filePath, g.unmarshal)
+}
+
+// LoadStrict is like Load, but a corrupt file is reported as an error and
+// left untouched. It is meant for files which are not owned by hbt.
+func (g *Graph) LoadStrict(filePath string) error {
+	b, err := cache.ReadFile(filePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Nothing to load
-			return nil
-		}
 		return err
 	}
-	sg := serialisableGraph{}
-	err = json.Unmarshal(b, &sg)
+	if err = g.unmarshal(b); err != nil {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+	return nil
+}
+
+func (g *Graph) unmarshal(b []byte) error {
+	sg, err := decode(b)
 	if err != nil {
 		return err
 	}
-	// Here we must do the opposite, where we start from the serialisable model
-	// and build the programmer-friendly one.
+	if err = sg.validate(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.load(sg)
+	return nil
+}
+
+// load is the opposite of serialisable, where we start from the serialisable
+// model and build the programmer-friendly one.
+func (g *Graph) load(sg *serialisableGraph) {
 	g.Nodes = map[string]*node{}
+	// Walkers would point to nodes which are gone when reloading.
+	g.walkers = map[string]walker{}
 	// First pass, lay down all node pointers
 	for id, wd := range sg.Wds {
 		g.Nodes[wd] = &node{
@@ -330,13 +425,20 @@ func (g *Graph) Load(filePath string) error {
 		}
 	}
 	// Second pass, do the same with edges
+	// Edges saved by older versions are considered used now, so that they do
+	// not get pruned right away.
+	loaded := now().Unix()
 	for nodeID, edges := range sg.Edges {
 		n := g.Nodes[sg.Wds[nodeID]]
 		for cmd, se := range edges {
 			e := &edge{
-				Hits: se.Hits,
-				From: n,
-				To:   nil,
+				Hits:     se.Hits,
+				From:     n,
+				To:       nil,
+				LastUsed: se.LastUsed,
+			}
+			if e.LastUsed == 0 {
+				e.LastUsed = loaded
 			}
 			if se.To != -1 {
 				e.To = g.Nodes[sg.Wds[se.To]]
@@ -344,5 +446,4 @@ func (g *Graph) Load(filePath string) error {
 			n.edges[cmd] = e
 		}
 	}
-	return nil
 }
@@ -3,21 +3,37 @@ package naive
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/lzambarda/hbt/graph"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// testNow is the time used by every test, so that saved graphs can be compared
+// to the files in testdata.
+var testNow = time.Unix(1640995200, 0)
+
 func TestNaive(t *testing.T) {
+	now = func() time.Time { return testNow }
+	defer func() { now = time.Now }()
 	t.Run("Node", testNaiveNode)
 	t.Run("Track", testNaiveTrack)
 	t.Run("Hint", testNaiveHint)
 	t.Run("Save", testNaiveSave)
 	t.Run("Load", testNaiveLoad)
 	t.Run("Delete", testNaiveDelete)
+	t.Run("Encoding", testNaiveEncoding)
+	t.Run("Corrupt", testNaiveCorrupt)
+	t.Run("Merge", testNaiveMerge)
+	t.Run("Prune", testNaivePrune)
+	t.Run("Forget", testNaiveForget)
 }
 
 func testNaiveNode(t *testing.T) {
@@ -231,3 +247,363 @@ func testNaiveDelete(t *testing.T) {
 	g.Delete("123", "abc", "def")
 	assert.EqualValues(t, shrug, g.Hint("123", "abc"))
 }
+
+func testNaiveEncoding(t *testing.T) {
+	runs := map[string]struct {
+		enc      Encoding
+		compress bool
+	}{
+		"json":        {EncodingJSON, false},
+		"json_gzip":   {EncodingJSON, true},
+		"binary":      {EncodingBinary, false},
+		"binary_gzip": {EncodingBinary, true},
+	}
+	for name, run := range runs {
+		t.Run(name, func(t *testing.T) {
+			expected := NewGraph(10, 3)
+			expected.Track("1", "dir1", "cmd1")
+			expected.Track("1", "dir2", "cmd2")
+			expected.Track("1", "dir1", "cmd3")
+			expected.Track("1", "dir1", "cmd3")
+			expected.Encoding = run.enc
+			expected.Compress = run.compress
+			filePath := path.Join(t.TempDir(), "cache")
+			err := expected.Save(filePath)
+			require.NoError(t, err)
+			expected.walkers = map[string]walker{}
+			expected.suggestionState = map[string]int{}
+
+			// Load must detect the encoding on its own.
+			actual := NewGraph(10, 3)
+			actual.Encoding = run.enc
+			actual.Compress = run.compress
+			err = actual.Load(filePath)
+			require.NoError(t, err)
+			assert.EqualValues(t, expected, actual)
+		})
+	}
+
+	t.Run("ParseEncoding", func(t *testing.T) {
+		enc, err := ParseEncoding("binary")
+		assert.NoError(t, err)
+		assert.Equal(t, EncodingBinary, enc)
+		enc, err = ParseEncoding("")
+		assert.NoError(t, err)
+		assert.Equal(t, EncodingJSON, enc)
+		_, err = ParseEncoding("xml")
+		assert.ErrorIs(t, err, ErrUnknownEncoding)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		g := NewGraph(10, 3)
+		g.Track("1", "dir1", "cmd1")
+		b, err := encode(g.serialisable(), EncodingBinary, false)
+		require.NoError(t, err)
+		_, err = decode(b[:len(b)-1])
+		assert.ErrorIs(t, err, ErrMalformedBinary)
+		_, err = decode(append(b, 0))
+		assert.ErrorIs(t, err, ErrMalformedBinary)
+	})
+}
+
+// benchmarkGraph returns a graph with many directories sharing a limited set
+// of commands, which is what a long shell history looks like.
+func benchmarkGraph() *Graph {
+	g := NewGraph(10, 3)
+	for i := 0; i < 2000; i++ {
+		wd := fmt.Sprintf("/home/user/Repositories/project%d/sub%d", i%200, i)
+		for j := 0; j < 20; j++ {
+			g.Track("1", wd, fmt.Sprintf("git commit -m \"change %d\"", (i+j)%300))
+		}
+	}
+	return g
+}
+
+var benchmarkFormats = []struct {
+	name     string
+	enc      Encoding
+	compress bool
+}{
+	{"json", EncodingJSON, false},
+	{"json_gzip", EncodingJSON, true},
+	{"binary", EncodingBinary, false},
+	{"binary_gzip", EncodingBinary, true},
+}
+
+func BenchmarkSave(b *testing.B) {
+	g := benchmarkGraph()
+	for _, f := range benchmarkFormats {
+		b.Run(f.name, func(b *testing.B) {
+			g.Encoding = f.enc
+			g.Compress = f.compress
+			filePath := path.Join(b.TempDir(), "cache")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := g.Save(filePath); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			if info, err := os.Stat(filePath); err == nil {
+				b.ReportMetric(float64(info.Size()), "bytes")
+			}
+		})
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	g := benchmarkGraph()
+	for _, f := range benchmarkFormats {
+		b.Run(f.name, func(b *testing.B) {
+			g.Encoding = f.enc
+			g.Compress = f.compress
+			filePath := path.Join(b.TempDir(), "cache")
+			if err := g.Save(filePath); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := NewGraph(10, 3).Load(filePath); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func testNaiveCorrupt(t *testing.T) {
+	t.Run("Backup", func(t *testing.T) {
+		filePath := path.Join(t.TempDir(), "cache")
+		g := NewGraph(10, 3)
+		g.Track("1", "dir1", "cmd1")
+		require.NoError(t, g.Save(filePath))
+		g.Track("1", "dir1", "cmd2")
+		require.NoError(t, g.Save(filePath))
+		// Flip a hit count, which is still valid JSON.
+		b, err := os.ReadFile(filePath)
+		require.NoError(t, err)
+		b = []byte(strings.Replace(string(b), `"h":1`, `"h":7`, 1))
+		require.NoError(t, os.WriteFile(filePath, b, 0o600))
+
+		actual := NewGraph(10, 3)
+		require.NoError(t, actual.Load(filePath))
+		assert.Contains(t, actual.Nodes, "dir1")
+		assert.Len(t, actual.Nodes["dir1"].edges, 1, "restored from the first save")
+		moved, err := filepath.Glob(filePath + ".corrupt-*")
+		require.NoError(t, err)
+		assert.Len(t, moved, 1)
+		assert.NoFileExists(t, filePath)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		filePath := path.Join(t.TempDir(), "cache")
+		require.NoError(t, os.WriteFile(filePath, []byte(`{"wds":["a"],"edges":[{"c":{"h":1,"t":5}}]}`), 0o600))
+		actual := NewGraph(10, 3)
+		require.NoError(t, actual.Load(filePath))
+		assert.Empty(t, actual.Nodes)
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		g := NewGraph(10, 3)
+		g.Track("1", "dir1", "cmd1")
+		b, err := encode(g.serialisable(), EncodingBinary, false)
+		require.NoError(t, err)
+		// Corrupt the last character of the command.
+		i := strings.Index(string(b), "cmd1") + 3
+		b[i] = '2'
+		sg, err := decode(b)
+		require.NoError(t, err)
+		assert.ErrorIs(t, sg.validate(), ErrCorrupt)
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		filePath := path.Join(t.TempDir(), "cache")
+		require.NoError(t, os.WriteFile(filePath, []byte(`{"wds":["a"],"edges":[{"c":{"h":1,"t":-1}}]}`), 0o600))
+		actual := NewGraph(10, 3)
+		require.NoError(t, actual.Load(filePath))
+		assert.Contains(t, actual.Nodes, "a")
+	})
+}
+
+func testNaiveMerge(t *testing.T) {
+	newGraphs := func() (*Graph, *Graph) {
+		a := NewGraph(10, 3)
+		a.Track("1", "/Users/x/repo", "make")
+		a.Track("1", "/Users/x/repo", "make")
+		a.Track("1", "/Users/x/other", "ls")
+		b := NewGraph(10, 3)
+		b.Track("1", "/home/x/repo", "make")
+		b.Track("1", "/home/x/repo", "git status")
+		b.Track("1", "/home/x/repository", "ls")
+		return a, b
+	}
+
+	t.Run("Sum", func(t *testing.T) {
+		a, b := newGraphs()
+		b.Merge(a, MergeOptions{
+			Strategy: MergeSum,
+			Remaps:   []Remap{{From: "/Users/x", To: "/home/x"}},
+		})
+		assert.Len(t, b.Nodes, 3)
+		repo := b.Nodes["/home/x/repo"]
+		require.NotNil(t, repo)
+		assert.Equal(t, 3, repo.edges["make"].Hits)
+		assert.Equal(t, 1, repo.edges["git status"].Hits)
+		assert.Equal(t, 1, b.Nodes["/home/x/repository"].edges["ls"].Hits)
+		other := b.Nodes["/home/x/other"]
+		require.NotNil(t, other)
+		assert.Equal(t, 2, other.id)
+		assert.Same(t, other, other.edges["ls"].From)
+		// a's make is followed by ls in /Users/x/other, and a has more hits.
+		assert.Same(t, other, repo.edges["make"].To)
+		// Unknown to a, left untouched.
+		assert.Same(t, repo, repo.edges["git status"].From)
+
+		// The result must be serialisable.
+		filePath := path.Join(t.TempDir(), "cache")
+		require.NoError(t, b.Save(filePath))
+		loaded := NewGraph(10, 3)
+		require.NoError(t, loaded.LoadStrict(filePath))
+		assert.Len(t, loaded.Nodes, 3)
+	})
+
+	t.Run("Max", func(t *testing.T) {
+		a, b := newGraphs()
+		b.Merge(a, MergeOptions{
+			Strategy: MergeMax,
+			Remaps:   []Remap{{From: "/Users/x", To: "/home/x"}},
+		})
+		assert.Equal(t, 2, b.Nodes["/home/x/repo"].edges["make"].Hits)
+	})
+
+	t.Run("Remap", func(t *testing.T) {
+		opts := MergeOptions{Remaps: []Remap{
+			{From: "/Users", To: "/usr"},
+			{From: "/Users/x", To: "/home/x"},
+		}}
+		assert.Equal(t, "/home/x/repo", opts.remap("/Users/x/repo"))
+		assert.Equal(t, "/home/x", opts.remap("/Users/x"))
+		assert.Equal(t, "/usr/xy", opts.remap("/Users/xy"))
+		assert.Equal(t, "/tmp", opts.remap("/tmp"))
+		r, err := ParseRemap("/Users/x/=/home/x")
+		assert.NoError(t, err)
+		assert.Equal(t, Remap{From: "/Users/x", To: "/home/x"}, r)
+		_, err = ParseRemap("/Users/x")
+		assert.Error(t, err)
+	})
+}
+
+func testNaivePrune(t *testing.T) {
+	day := 24 * time.Hour
+	// Tracks cmd at wd hits times, daysAgo days before testNow.
+	track := func(g *Graph, daysAgo int, wd, cmd string, hits int) {
+		now = func() time.Time { return testNow.Add(-time.Duration(daysAgo) * day) }
+		defer func() { now = func() time.Time { return testNow } }()
+		for i := 0; i < hits; i++ {
+			g.Track("1", wd, cmd)
+		}
+	}
+	newGraph := func() *Graph {
+		g := NewGraph(10, 3)
+		track(g, 101, "/repo", "cd ../old", 1)
+		track(g, 100, "/old", "make", 5)
+		track(g, 10, "/repo", "ls", 1)
+		track(g, 1, "/repo", "git status", 1)
+		track(g, 0, "/repo", "make", 3)
+		track(g, 0, "/other", "go test", 2)
+		return g
+	}
+
+	t.Run("MaxAge", func(t *testing.T) {
+		g := newGraph()
+		result := g.Prune(graph.PruneOptions{MaxAge: 30 * day})
+		assert.Equal(t, graph.PruneResult{Nodes: 1, Edges: 2}, result)
+		assert.NotContains(t, g.Nodes, "/old")
+		assert.Len(t, g.Nodes["/repo"].edges, 3)
+		assertConsistent(t, g)
+		filePath := path.Join(t.TempDir(), "cache")
+		require.NoError(t, g.Save(filePath))
+		require.NoError(t, NewGraph(10, 3).LoadStrict(filePath))
+		g.Track("2", "/new", "ls")
+		require.NoError(t, g.Save(filePath))
+		require.NoError(t, NewGraph(10, 3).LoadStrict(filePath))
+	})
+
+	t.Run("MinHits", func(t *testing.T) {
+		g := newGraph()
+		result := g.Prune(graph.PruneOptions{MinHits: 2, MinHitsGrace: 7 * day})
+		assert.Equal(t, graph.PruneResult{Nodes: 0, Edges: 2}, result)
+		assert.NotContains(t, g.Nodes["/repo"].edges, "ls")
+		assert.Contains(t, g.Nodes["/repo"].edges, "git status", "still within the grace period")
+	})
+
+	t.Run("MaxEdgesPerNode", func(t *testing.T) {
+		g := newGraph()
+		g.Prune(graph.PruneOptions{MaxEdgesPerNode: 2})
+		assert.Len(t, g.Nodes["/repo"].edges, 2)
+		assert.Contains(t, g.Nodes["/repo"].edges, "make")
+		assert.Contains(t, g.Nodes["/repo"].edges, "git status", "more recent than ls")
+	})
+
+	t.Run("MaxNodes", func(t *testing.T) {
+		g := newGraph()
+		require.Same(t, g.Nodes["/old"], g.Nodes["/repo"].edges["cd ../old"].To)
+		result := g.Prune(graph.PruneOptions{MaxNodes: 2})
+		assert.Equal(t, graph.PruneResult{Nodes: 1, Edges: 1}, result)
+		assert.NotContains(t, g.Nodes, "/old")
+		assert.Nil(t, g.Nodes["/repo"].edges["cd ../old"].To)
+		assertConsistent(t, g)
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		g := newGraph()
+		result := g.Prune(graph.PruneOptions{MaxAge: 30 * day, DryRun: true})
+		assert.Equal(t, graph.PruneResult{Nodes: 1, Edges: 2}, result)
+		assert.Contains(t, g.Nodes, "/old")
+	})
+}
+
+// assertConsistent checks that no edge points to a node which is not in the
+// graph anymore and that node ids are contiguous.
+func assertConsistent(t *testing.T, g *Graph) {
+	t.Helper()
+	ids := map[int]bool{}
+	nodes := map[*node]bool{}
+	for _, n := range g.Nodes {
+		ids[n.id] = true
+		nodes[n] = true
+	}
+	for i := 0; i < len(g.Nodes); i++ {
+		assert.True(t, ids[i], "missing id %d", i)
+	}
+	for wd, n := range g.Nodes {
+		for cmd, e := range n.edges {
+			if e.To != nil {
+				assert.True(t, nodes[e.To], "%s %s points to a removed node", wd, cmd)
+			}
+		}
+	}
+}
+
+func testNaiveForget(t *testing.T) {
+	g := NewGraph(10, 3)
+	g.RecordDelta()
+	g.Track("1", "/a", "export TOKEN=x")
+	g.Track("1", "/b", "export TOKEN=x")
+	g.Track("1", "/b", "make")
+	match := func(cmd string) bool { return strings.Contains(cmd, "TOKEN") }
+
+	forgotten := g.Forget(match, true)
+	assert.Equal(t, []graph.Forgotten{
+		{Wd: "/a", Cmd: "export TOKEN=x", Hits: 1},
+		{Wd: "/b", Cmd: "export TOKEN=x", Hits: 1},
+	}, forgotten)
+	assert.Len(t, g.Nodes["/a"].edges, 1, "dry run")
+
+	forgotten = g.Forget(match, false)
+	assert.Len(t, forgotten, 2)
+	assert.Empty(t, g.Nodes["/a"].edges)
+	assert.Len(t, g.Nodes["/b"].edges, 1)
+	assert.Len(t, g.delta.Nodes["/b"].edges, 1, "not exported to other hosts either")
+	assert.Empty(t, g.Forget(match, false))
+}
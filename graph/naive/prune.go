@@ -0,0 +1,128 @@
+package naive
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lzambarda/hbt/graph"
+)
+
+// Prune removes the stale knowledge according to opts. Directories left
+// without commands are removed too, as well as any edge pointing to them.
+func (g *Graph) Prune(opts graph.PruneOptions) graph.PruneResult {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := now()
+	// edge -> true if removed
+	removed := map[*edge]bool{}
+	for _, n := range g.Nodes {
+		kept := make([]*cmdEdge, 0, len(n.edges))
+		for cmd, e := range n.edges {
+			age := t.Sub(time.Unix(e.LastUsed, 0))
+			switch {
+			case opts.MaxAge > 0 && age > opts.MaxAge,
+				opts.MinHits > 0 && e.Hits < opts.MinHits && age > opts.MinHitsGrace:
+				removed[e] = true
+			default:
+				kept = append(kept, &cmdEdge{cmd, e.Hits})
+			}
+		}
+		if opts.MaxEdgesPerNode > 0 && len(kept) > opts.MaxEdgesPerNode {
+			sort.Slice(kept, func(i, j int) bool {
+				if kept[i].score != kept[j].score {
+					return kept[i].score > kept[j].score
+				}
+				return n.edges[kept[i].cmd].LastUsed > n.edges[kept[j].cmd].LastUsed
+			})
+			for _, ce := range kept[opts.MaxEdgesPerNode:] {
+				removed[n.edges[ce.cmd]] = true
+			}
+		}
+	}
+
+	// Directories are ranked by their most recent use, ignoring the edges
+	// removed so far.
+	type rankedNode struct {
+		wd       string
+		lastUsed int64
+	}
+	ranked := make([]rankedNode, 0, len(g.Nodes))
+	for wd, n := range g.Nodes {
+		r := rankedNode{wd: wd}
+		for _, e := range n.edges {
+			if !removed[e] && e.LastUsed > r.lastUsed {
+				r.lastUsed = e.LastUsed
+			}
+		}
+		ranked = append(ranked, r)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].lastUsed != ranked[j].lastUsed {
+			return ranked[i].lastUsed > ranked[j].lastUsed
+		}
+		return ranked[i].wd < ranked[j].wd
+	})
+	removedNodes := map[*node]bool{}
+	for i, r := range ranked {
+		n := g.Nodes[r.wd]
+		empty := true
+		for _, e := range n.edges {
+			if !removed[e] {
+				empty = false
+				break
+			}
+		}
+		if empty || (opts.MaxNodes > 0 && i >= opts.MaxNodes) {
+			removedNodes[n] = true
+			for _, e := range n.edges {
+				removed[e] = true
+			}
+		}
+	}
+
+	result := graph.PruneResult{
+		Nodes: len(removedNodes),
+		Edges: len(removed),
+	}
+	if opts.DryRun {
+		return result
+	}
+	for wd, n := range g.Nodes {
+		if removedNodes[n] {
+			delete(g.Nodes, wd)
+			continue
+		}
+		for cmd, e := range n.edges {
+			if removed[e] {
+				delete(n.edges, cmd)
+				continue
+			}
+			if removedNodes[e.To] {
+				e.To = nil
+			}
+		}
+	}
+	// Sessions pointing to removed knowledge start over.
+	for id, w := range g.walkers {
+		if len(w) > 0 && (removed[w[0].lastEdge] || removedNodes[w[0].lastNode]) {
+			delete(g.walkers, id)
+		}
+	}
+	g.reindex()
+	return result
+}
+
+// reindex makes node ids contiguous again after nodes have been removed, as
+// both Save and newNode rely on it. The relative order of ids is preserved.
+func (g *Graph) reindex() {
+	nodes := make([]*node, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].id < nodes[j].id
+	})
+	for i, n := range nodes {
+		n.id = i
+	}
+}
@@ -0,0 +1,67 @@
+package naive
+
+import (
+	"sort"
+
+	"github.com/lzambarda/hbt/graph"
+)
+
+// Stats describes the graph, listing at most top commands and directories, as
+// well as top commands for each directory listed. A negative top lists
+// nothing.
+func (g *Graph) Stats(top int) graph.Stats {
+	if top < 0 {
+		top = 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := graph.Stats{Directories: len(g.Nodes)}
+	hits := map[string]int{}
+	dirs := make([]graph.DirectoryStats, 0, len(g.Nodes))
+	for wd, n := range g.Nodes {
+		d := graph.DirectoryStats{Wd: wd, Commands: len(n.edges)}
+		for cmd, e := range n.edges {
+			d.Hits += e.Hits
+			hits[cmd] += e.Hits
+		}
+		s.Commands += d.Commands
+		s.Hits += d.Hits
+		dirs = append(dirs, d)
+	}
+	s.TopCommands = topCommands(hits, top)
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Hits != dirs[j].Hits {
+			return dirs[i].Hits > dirs[j].Hits
+		}
+		return dirs[i].Wd < dirs[j].Wd
+	})
+	if len(dirs) > top {
+		dirs = dirs[:top]
+	}
+	for i := range dirs {
+		hits := map[string]int{}
+		for cmd, e := range g.Nodes[dirs[i].Wd].edges {
+			hits[cmd] = e.Hits
+		}
+		dirs[i].Top = topCommands(hits, top)
+	}
+	s.TopDirectories = dirs
+	return s
+}
+
+func topCommands(hits map[string]int, top int) []graph.CommandStats {
+	cmds := make([]graph.CommandStats, 0, len(hits))
+	for cmd, h := range hits {
+		cmds = append(cmds, graph.CommandStats{Cmd: cmd, Hits: h})
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		if cmds[i].Hits != cmds[j].Hits {
+			return cmds[i].Hits > cmds[j].Hits
+		}
+		return cmds[i].Cmd < cmds[j].Cmd
+	})
+	if len(cmds) > top {
+		cmds = cmds[:top]
+	}
+	return cmds
+}
@@ -0,0 +1,104 @@
+// Package ignore keeps unwanted commands and directories out of the graph.
+package ignore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RegexPrefix marks a command rule as a regular expression rather than a
+// glob.
+const RegexPrefix = "re:"
+
+type commandRule struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Rules decides which commands must not be tracked.
+// It is safe for concurrent use once created.
+type Rules struct {
+	commands []commandRule
+	dirs     []string
+	// Like zsh HIST_IGNORE_SPACE, ignore commands starting with a space.
+	space bool
+}
+
+// New returns Rules ignoring:
+//   - the commands matching any of the given patterns. Patterns are globs,
+//     where * matches any sequence of characters and ? a single one, unless
+//     they start with RegexPrefix. A glob must match either the whole command
+//     or its first word, so that "ls" ignores "ls -la" too. Regular
+//     expressions are not anchored;
+//   - the commands run in any of the given directories or their
+//     subdirectories. A leading ~ is expanded to the home directory;
+//   - the commands starting with a space, if space is true.
+func New(commands, dirs []string, space bool) (*Rules, error) {
+	r := &Rules{space: space}
+	for _, c := range commands {
+		var re *regexp.Regexp
+		var err error
+		if strings.HasPrefix(c, RegexPrefix) {
+			re, err = regexp.Compile(strings.TrimPrefix(c, RegexPrefix))
+		} else {
+			re, err = regexp.Compile("^" + globToRegex(c) + "$")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ignore rule %q: %w", c, err)
+		}
+		r.commands = append(r.commands, commandRule{c, re})
+	}
+	for _, d := range dirs {
+		if d == "~" || strings.HasPrefix(d, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			d = home + strings.TrimPrefix(d, "~")
+		}
+		r.dirs = append(r.dirs, filepath.Clean(d))
+	}
+	return r, nil
+}
+
+func globToRegex(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// Filter returns false if cmd or wd must be ignored, along with the rule which
+// matched. Commands are never rewritten.
+func (r *Rules) Filter(wd, cmd string) (filtered, rule string, ok bool) {
+	if r.space && strings.HasPrefix(cmd, " ") {
+		return "", "ignore:leading-space", false
+	}
+	for _, d := range r.dirs {
+		if wd == d || strings.HasPrefix(wd, d+"/") || d == "/" {
+			return "", "ignore:dir:" + d, false
+		}
+	}
+	trimmed := strings.TrimSpace(cmd)
+	first := trimmed
+	if i := strings.IndexAny(trimmed, " \t"); i >= 0 {
+		first = trimmed[:i]
+	}
+	for _, c := range r.commands {
+		if c.re.MatchString(trimmed) || (!strings.HasPrefix(c.pattern, RegexPrefix) && c.re.MatchString(first)) {
+			return "", "ignore:command:" + c.pattern, false
+		}
+	}
+	return cmd, "", true
+}
@@ -0,0 +1,50 @@
+package ignore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnore(t *testing.T) {
+	r, err := New([]string{"ls", "cd", "git push*", "re:^rm -rf"}, []string{"/tmp", "~/scratch"}, true)
+	require.NoError(t, err)
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	runs := []struct {
+		wd, cmd, rule string
+	}{
+		{"/repo", "ls", "ignore:command:ls"},
+		{"/repo", "ls -la", "ignore:command:ls"},
+		{"/repo", "  cd ..", "ignore:leading-space"},
+		{"/repo", "cd ..", "ignore:command:cd"},
+		{"/repo", "git push --force", "ignore:command:git push*"},
+		{"/repo", "rm -rf /", "ignore:command:re:^rm -rf"},
+		{"/tmp", "make", "ignore:dir:/tmp"},
+		{"/tmp/x", "make", "ignore:dir:/tmp"},
+		{home + "/scratch/y", "make", "ignore:dir:" + home + "/scratch"},
+		{"/tmpfoo", "make", ""},
+		{"/repo", "lsof", ""},
+		{"/repo", "git pull", ""},
+		{"/repo", "echo rm -rf", ""},
+	}
+	for _, run := range runs {
+		cmd, rule, ok := r.Filter(run.wd, run.cmd)
+		assert.Equal(t, run.rule, rule, run.cmd)
+		assert.Equal(t, run.rule == "", ok, run.cmd)
+		if ok {
+			assert.Equal(t, run.cmd, cmd)
+		}
+	}
+
+	r, err = New(nil, nil, false)
+	require.NoError(t, err)
+	_, _, ok := r.Filter("/repo", " secret")
+	assert.True(t, ok, "leading space allowed")
+
+	_, err = New([]string{"re:("}, nil, false)
+	assert.Error(t, err)
+}
@@ -4,29 +4,122 @@ package internal
 import "time"
 
 const (
-	DebugName        = "HBT_DEBUG"
-	CachePathName    = "HBT_CACHE_PATH"
-	PortName         = "HBT_PORT"
-	SaveIntervalName = "HBT_SAVE_INTERVAL"
+	DebugName              = "HBT_DEBUG"
+	CachePathName          = "HBT_CACHE_PATH"
+	StateDirName           = "HBT_STATE_DIR"
+	RuntimeDirName         = "HBT_RUNTIME_DIR"
+	LogFileName            = "HBT_LOG_FILE"
+	LogFormatName          = "HBT_LOG_FORMAT"
+	LogLevelName           = "HBT_LOG_LEVEL"
+	LogMaxSizeName         = "HBT_LOG_MAX_SIZE"
+	LogBackupsName         = "HBT_LOG_BACKUPS"
+	PortName               = "HBT_PORT"
+	SaveIntervalName       = "HBT_SAVE_INTERVAL"
+	CacheEncodingName      = "HBT_CACHE_ENCODING"
+	CacheCompressName      = "HBT_CACHE_COMPRESS"
+	BackupsName            = "HBT_BACKUPS"
+	SyncDirName            = "HBT_SYNC_DIR"
+	SyncHostName           = "HBT_SYNC_HOST"
+	SyncIntervalName       = "HBT_SYNC_INTERVAL"
+	CacheKeyName           = "HBT_CACHE_KEY"
+	CacheKeyFileName       = "HBT_CACHE_KEY_FILE"
+	RedactName             = "HBT_REDACT"
+	RedactRulesName        = "HBT_REDACT_RULES"
+	IgnoreCommandsName     = "HBT_IGNORE_COMMANDS"
+	IgnoreDirsName         = "HBT_IGNORE_DIRS"
+	IgnoreSpaceName        = "HBT_IGNORE_SPACE"
+	PruneIntervalName      = "HBT_PRUNE_INTERVAL"
+	PruneMaxAgeName        = "HBT_PRUNE_MAX_AGE"
+	PruneMinHitsName       = "HBT_PRUNE_MIN_HITS"
+	PruneMaxEdgesName      = "HBT_PRUNE_MAX_EDGES"
+	PruneMaxNodesName      = "HBT_PRUNE_MAX_NODES"
+	SessionTTLName         = "HBT_SESSION_TTL"
+	ClientTimeoutName      = "HBT_CLIENT_TIMEOUT"
+	AutoSpawnName          = "HBT_AUTOSPAWN"
+	IdleExitName           = "HBT_IDLE_EXIT"
+	ConfigName             = "HBT_CONFIG"
+	GraphName              = "HBT_GRAPH"
+	GraphMaxHistoryName    = "HBT_GRAPH_MAX_HISTORY"
+	GraphMinCommonPathName = "HBT_GRAPH_MIN_COMMON_PATH"
 )
 
 const (
 	// Found by looking at unused ports at:
 	// https://en.wikipedia.org/wiki/List_of_TCP_and_UDP_port_numbers
-	DefaultPort         = "43111"
-	DefaultCachePath    = "."
-	DefaultSaveInterval = time.Minute * 10
+	DefaultPort = "43111"
+	// Requests are not authenticated, so only local processes may send them.
+	Host                 = "127.0.0.1"
+	DefaultSaveInterval  = time.Minute * 10
+	DefaultCacheEncoding = "json"
+	DefaultBackups       = 3
+	DefaultSyncInterval  = time.Minute
+	DefaultRedact        = "mask"
+	DefaultPruneInterval = time.Hour * 24
+	DefaultSessionTTL    = time.Hour * 24
+	// Long enough for a busy server, short enough not to hang the shell.
+	DefaultClientTimeout = time.Second * 2
+	// One-off commands get a week to be repeated before MinHits applies.
+	DefaultPruneMinHitsGrace = time.Hour * 24 * 7
+	DefaultGraph             = "naive"
+	DefaultLogFormat         = "text"
+	DefaultLogLevel          = "info"
+	// In megabytes.
+	DefaultLogMaxSize = 10
+	DefaultLogBackups = 3
+	// How many commands the graph remembers per session.
+	DefaultGraphMaxHistory = 10
+	// How many trailing path components must match to suggest the commands of
+	// another directory.
+	DefaultGraphMinCommonPath = 3
 )
 
 var (
-	Debug        bool
-	CachePath    string
-	Port         string
-	SaveInterval time.Duration
+	Debug              bool
+	CachePath          string
+	StateDir           string
+	RuntimeDir         string
+	LogFile            string
+	LogFormat          string
+	LogLevel           string
+	LogMaxSize         int
+	LogBackups         int
+	Port               string
+	SaveInterval       time.Duration
+	CacheEncoding      string
+	CacheCompress      bool
+	Backups            = DefaultBackups
+	SyncDir            string
+	SyncHost           string
+	SyncInterval       time.Duration
+	CacheKeyFile       string
+	Redact             string
+	IgnoreSpace        bool
+	PruneInterval      time.Duration
+	PruneMaxAge        time.Duration
+	PruneMinHits       int
+	PruneMaxEdges      int
+	PruneMaxNodes      int
+	SessionTTL         time.Duration
+	ClientTimeout      time.Duration
+	AutoSpawn          bool
+	IdleExit           time.Duration
+	ConfigPath         string
+	Graph              string
+	GraphMaxHistory    int
+	GraphMinCommonPath int
 	// Must be var, otherwise -X flag can't modify it.
 	Version = "unknown"
 )
 
 const (
-	CacheName = ".hbtcache"
+	CacheName     = ".hbtcache"
+	SyncStateName = "sync"
+	PidName       = "hbt.pid"
+	// Locked by the server using the cache, in the cache directory.
+	LockName = ".hbtlock"
+	// Log file of the servers started in the background, in the state
+	// directory.
+	LogName = "hbt.log"
+	// Where older versions kept the sync state, next to the cache.
+	LegacySyncStateName = ".hbtsync"
 )
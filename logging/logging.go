@@ -0,0 +1,151 @@
+// Package logging sets up the structured logs of hbt, which every package
+// writes through the default slog logger.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Formats of the logs.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configure the logs.
+type Options struct {
+	// Minimum level of the logs, one of debug, info, warn and error.
+	Level string
+	// One of FormatText and FormatJSON.
+	Format string
+	// File to log to, stderr if empty.
+	File string
+	// Size in bytes beyond which File is rotated, 0 disables the rotation.
+	MaxSize int64
+	// How many rotated files to keep.
+	Backups int
+}
+
+// Setup makes the default logger follow opts. The returned io.Closer closes
+// the log file, if any.
+func Setup(opts Options) (io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", opts.Level)
+	}
+	var w io.WriteCloser = nopCloser{os.Stderr}
+	if opts.File != "" {
+		f, err := OpenFile(opts.File, opts.MaxSize, opts.Backups)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case FormatText:
+		h = slog.NewTextHandler(w, handlerOpts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		w.Close() //nolint:errcheck,gosec // Already failing.
+		return nil, fmt.Errorf("invalid log format %q, expected %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+	slog.SetDefault(slog.New(h))
+	return w, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// File appends to a file, which is rotated once it would grow beyond a
+// maximum size: it is renamed with a .1 suffix, after shifting the previous
+// ones, and a new one is started.
+// It is safe for concurrent use.
+type File struct {
+	path    string
+	maxSize int64
+	backups int
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+}
+
+// OpenFile opens the file at filePath for appending, creating it and its
+// directory if needed. It is rotated beyond maxSize bytes, unless maxSize is
+// 0, and at most backups rotated files are kept.
+func OpenFile(filePath string, maxSize int64, backups int) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o700); err != nil {
+		return nil, err
+	}
+	f := &File{path: filePath, maxSize: maxSize, backups: backups}
+	return f, f.open()
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() //nolint:errcheck,gosec // Already failing.
+		return err
+	}
+	f.f, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if needed. A single write
+// is never split across files.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	for n := f.backups - 1; n > 0; n-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", f.path, n), fmt.Sprintf("%s.%d", f.path, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var err error
+	if f.backups > 0 {
+		err = os.Rename(f.path, f.path+".1")
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
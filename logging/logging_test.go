@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging(t *testing.T) {
+	t.Run("Setup", testLoggingSetup)
+	t.Run("Rotate", testLoggingRotate)
+}
+
+func testLoggingSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	filePath := filepath.Join(t.TempDir(), "logs", "hbt.log")
+	closer, err := Setup(Options{Level: "info", Format: "json", File: filePath})
+	require.NoError(t, err)
+	slog.Debug("hidden")
+	slog.Info("request", "command", "hint", "session", "1")
+	require.NoError(t, closer.Close())
+
+	b, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 1, "debug logs are filtered out")
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "INFO", entry["level"])
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "hint", entry["command"])
+	assert.Equal(t, "1", entry["session"])
+
+	_, err = Setup(Options{Level: "loud", Format: "text"})
+	assert.EqualError(t, err, `invalid log level "loud"`)
+	_, err = Setup(Options{Level: "info", Format: "xml"})
+	assert.EqualError(t, err, `invalid log format "xml", expected text or json`)
+}
+
+func testLoggingRotate(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "hbt.log")
+	f, err := OpenFile(filePath, 10, 2)
+	require.NoError(t, err)
+	for _, s := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddddddddddd\n", "eeee\n"} {
+		_, err = f.Write([]byte(s))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	assertContent(t, filePath, "eeee\n")
+	assertContent(t, filePath+".1", "dddddddddddd\n")
+	assertContent(t, filePath+".2", "cccc\n")
+	assert.NoFileExists(t, filePath+".3")
+
+	f, err = OpenFile(filePath, 10, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("ffff\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assertContent(t, filePath, "eeee\nffff\n", "appends to the existing file")
+}
+
+func assertContent(t *testing.T, filePath, expected string, msgAndArgs ...interface{}) {
+	t.Helper()
+	b, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(b), msgAndArgs...)
+}
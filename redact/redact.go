@@ -0,0 +1,222 @@
+// Package redact keeps secrets typed on the command line out of the graph.
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Mode defines what happens to a command containing a secret.
+type Mode string
+
+const (
+	// ModeMask replaces the secrets with Mask and tracks the command.
+	ModeMask Mode = "mask"
+	// ModeDrop does not track the command at all.
+	ModeDrop Mode = "drop"
+	// ModeOff disables the redaction.
+	ModeOff Mode = "off"
+)
+
+// Mask replaces secrets in masked commands.
+const Mask = "***"
+
+// ErrUnknownMode is returned when parsing an unsupported mode.
+var ErrUnknownMode = errors.New("unknown redaction mode")
+
+// ParseMode returns the Mode matching the given name.
+func ParseMode(name string) (Mode, error) {
+	switch m := Mode(name); m {
+	case ModeMask, ModeDrop, ModeOff:
+		return m, nil
+	case "":
+		return ModeMask, nil
+	default:
+		return "", fmt.Errorf("%q: %w", name, ErrUnknownMode)
+	}
+}
+
+type rule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Built-in rules. When a rule has a group named secret only that group is
+// masked, otherwise the whole match is.
+var builtins = []rule{
+	{
+		"env-assignment",
+		regexp.MustCompile(`(?i)\b[A-Z0-9_]*(?:TOKEN|SECRET|PASSWORD|PASSWD|API_?KEY|ACCESS_KEY|PRIVATE_KEY|CREDENTIALS?)[A-Z0-9_]*=(?P<secret>"[^"]*"|'[^']*'|\S+)`),
+	},
+	{
+		"password-flag",
+		regexp.MustCompile(`(?i)(?:^|\s)--?(?:password|passwd|pass|token|secret|api-?key|auth-token|client-secret)[= ](?P<secret>"[^"]*"|'[^']*'|\S+)`),
+	},
+	{
+		"mysql-password",
+		regexp.MustCompile(`\b(?:mysql|mariadb|mysqldump|mysqladmin)\b.*\s-p(?P<secret>[^\s-]\S*)`),
+	},
+	{
+		"sshpass",
+		regexp.MustCompile(`\bsshpass\s+-p\s*(?P<secret>\S+)`),
+	},
+	{
+		"authorization-header",
+		regexp.MustCompile(`(?i)\b(?:authorization|x-api-key|private-token):\s*(?:(?:bearer|basic|token)\s+)?(?P<secret>[^\s"']+)`),
+	},
+	{
+		"basic-auth-flag",
+		regexp.MustCompile(`\b(?:curl|wget|http)\b.*\s(?:-u|--user)\s+[^\s:]+:(?P<secret>\S+)`),
+	},
+	{
+		"url-credentials",
+		regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^\s:/@]+:(?P<secret>[^\s@/]+)@`),
+	},
+	{
+		"known-key-prefix",
+		regexp.MustCompile(`\b(?:AKIA[0-9A-Z]{16}|ASIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}|glpat-[A-Za-z0-9_-]{20,}|xox[abprs]-[A-Za-z0-9-]{10,}|sk-[A-Za-z0-9_-]{20,}|AIza[0-9A-Za-z_-]{35}|npm_[A-Za-z0-9]{36}|eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)`),
+	},
+	{
+		"private-key",
+		regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	},
+}
+
+const (
+	// Words shorter than this are never considered random.
+	minEntropyLength = 24
+	// Shannon entropy, in bits per character, above which a word is
+	// considered random. Hexadecimal hashes (e.g. git commits) stay below it.
+	minEntropy = 4.2
+)
+
+// Redactor finds secrets in commands.
+// It is safe for concurrent use once created.
+type Redactor struct {
+	mode  Mode
+	rules []rule
+}
+
+// New returns a Redactor using the built-in rules and the given regular
+// expressions. As for the built-in rules, a group named secret can be used to
+// only mask part of the match.
+func New(mode Mode, extra []string) (*Redactor, error) {
+	r := &Redactor{
+		mode:  mode,
+		rules: append([]rule{}, builtins...),
+	}
+	for i, e := range extra {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %q: %w", e, err)
+		}
+		r.rules = append(r.rules, rule{fmt.Sprintf("user-%d", i+1), re})
+	}
+	return r, nil
+}
+
+// Filter returns cmd with its secrets masked, or false if it contains any and
+// the mode is ModeDrop. The names of the matched rules are returned too.
+func (r *Redactor) Filter(_, cmd string) (filtered, matched string, ok bool) {
+	if r.mode == ModeOff {
+		return cmd, "", true
+	}
+	var names []string
+	for _, ru := range r.rules {
+		masked := mask(ru.re, cmd)
+		if masked != cmd {
+			names = append(names, ru.name)
+			cmd = masked
+		}
+	}
+	if masked := maskRandom(cmd); masked != cmd {
+		names = append(names, "high-entropy")
+		cmd = masked
+	}
+	if len(names) == 0 {
+		return cmd, "", true
+	}
+	matched = "redact:" + strings.Join(names, ",")
+	if r.mode == ModeDrop {
+		return "", matched, false
+	}
+	return cmd, matched, true
+}
+
+// mask replaces every match of re in s, or only its secret group if it has
+// one.
+func mask(re *regexp.Regexp, s string) string {
+	group := re.SubexpIndex("secret")
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if group > 0 && m[2*group] >= 0 {
+			start, end = m[2*group], m[2*group+1]
+		}
+		if s[start:end] == Mask {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(Mask)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// wordSeparators split a command in the words checked by maskRandom.
+const wordSeparators = " \t\n=:'\",;@"
+
+// maskRandom masks the words which look randomly generated, such as tokens
+// without a recognisable prefix.
+func maskRandom(s string) string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return strings.ContainsRune(wordSeparators, r)
+	})
+	for _, f := range fields {
+		// Paths and URLs are long but seldom secret.
+		if len(f) < minEntropyLength || strings.ContainsAny(f, "/\\.") {
+			continue
+		}
+		if entropy(f) >= minEntropy && hasLettersAndDigits(f) {
+			s = strings.ReplaceAll(s, f, Mask)
+		}
+	}
+	return s
+}
+
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+func hasLettersAndDigits(s string) bool {
+	letters, digits := false, false
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letters = true
+		}
+	}
+	return letters && digits
+}
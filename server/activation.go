@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The first file descriptor passed by the service manager, after stdin, stdout
+// and stderr.
+const listenFDsStart = 3
+
+// activationListeners returns the listeners passed by the service manager,
+// following the socket activation convention of systemd: LISTEN_PID is the pid
+// of the process they are meant for and LISTEN_FDS how many there are, from
+// file descriptor 3 on. LISTEN_FDNAMES optionally names them.
+// It returns nothing if no listener was passed to this process. The variables
+// are unset, so that child processes do not inherit them.
+func activationListeners() ([]net.Listener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if err := os.Unsetenv(name); err != nil {
+			return nil, err
+		}
+	}
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", fds)
+	}
+	fdNames := strings.Split(names, ":")
+	ls := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - listenFDsStart; i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// The listener has its own copy of the file descriptor.
+		f.Close() //nolint:errcheck,gosec // It is okay.
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lzambarda/hbt/graph/naive"
+	"github.com/lzambarda/hbt/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// activatedName makes the test binary run a server instead of the tests, see
+// testActivation.
+const activatedName = "HBT_TEST_ACTIVATED"
+
+func TestMain(m *testing.M) {
+	if cachePath := os.Getenv(activatedName); cachePath != "" {
+		internal.SaveInterval = time.Hour
+		internal.IdleExit = 200 * time.Millisecond
+		if err := New(naive.NewGraph(10, 3), cachePath).Start(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestActivation(t *testing.T) {
+	t.Run("Listeners", testActivationListeners)
+	t.Run("OtherProcess", testActivationOtherProcess)
+	t.Run("IdleExit", testActivationIdleExit)
+}
+
+// request sends args to the server at addr like the client does.
+func request(t *testing.T, addr string, args ...string) string {
+	t.Helper()
+	c, err := net.DialTimeout("tcp4", addr, time.Second)
+	require.NoError(t, err)
+	defer c.Close() //nolint:errcheck // It is okay.
+	require.NoError(t, c.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = c.Write(EncodeRequest(args))
+	require.NoError(t, err)
+	require.NoError(t, c.(*net.TCPConn).CloseWrite())
+	result, err := io.ReadAll(c)
+	require.NoError(t, err)
+	return string(result)
+}
+
+// testActivationListeners passes a listener to a server the way a service
+// manager does, and checks that it serves it then exits once idle.
+func testActivationListeners(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	cachePath := path.Join(t.TempDir(), "cache")
+	// LISTEN_PID must be the pid of the server, which only the shell knows
+	// before exec.
+	cmd := exec.Command("sh", "-c", `LISTEN_PID=$$ LISTEN_FDS=1 LISTEN_FDNAMES=hbt exec "$0" -test.run=^$`, os.Args[0])
+	cmd.Env = append(os.Environ(), activatedName+"="+cachePath)
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	require.NoError(t, cmd.Start())
+	require.NoError(t, f.Close())
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	assert.Equal(t, "pong", request(t, addr, "ping"), "connections queued before the server starts are served")
+	request(t, addr, "track", "1", "/d", "make")
+	select {
+	case err = <-exited:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill() //nolint:errcheck,gosec // It is okay.
+		require.FailNow(t, "the server did not exit once idle")
+	}
+	g := naive.NewGraph(10, 3)
+	require.NoError(t, g.Load(cachePath))
+	assert.Equal(t, "make", g.Hint("1", "/d"), "the graph is saved before exiting")
+}
+
+func testActivationOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	ls, err := activationListeners()
+	require.NoError(t, err)
+	assert.Empty(t, ls, "listeners passed to another process are ignored")
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	assert.False(t, ok, "the variables are not inherited")
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "one")
+	_, err = activationListeners()
+	assert.Error(t, err)
+}
+
+func testActivationIdleExit(t *testing.T) {
+	cachePath := path.Join(t.TempDir(), "cache")
+	s := New(naive.NewGraph(10, 3), cachePath)
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	s.idleRoutine(300 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		request(t, l.Addr().String(), "track", "1", "/d", "make")
+	}
+	select {
+	case err = <-served:
+		require.Fail(t, "the server exited while in use", "%v", err)
+	default:
+	}
+	select {
+	case err = <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the server did not exit once idle")
+	}
+	_, err = os.Stat(cachePath)
+	assert.NoError(t, err, "the graph is saved before exiting")
+}
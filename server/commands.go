@@ -0,0 +1,164 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// command is a request the server understands.
+type command struct {
+	// Expected number of arguments, including the command name. Commands
+	// with optional arguments set it to 0 and check them on their own.
+	args int
+	run  func(s *Server, args []string) (string, error)
+}
+
+// sessionCommands lists the commands whose first argument is a session id.
+var sessionCommands = map[string]bool{
+	"track": true, "hint": true, "end": true, "del": true, "incognito": true, "session": true, "explain": true,
+}
+
+// swapping lists the commands reconfiguring or reloading the graph, which
+// must not hold Server.swap while running.
+var swapping = map[string]bool{"reload": true}
+
+// commands maps the name of every command to its implementation.
+var commands = map[string]command{
+	// track <id> <wd> <cmd>
+	"track": {4, func(s *Server, args []string) (string, error) {
+		if s.touch(args[1]) {
+			slog.Debug("Session is incognito, not tracking", "session", args[1])
+			return "", nil
+		}
+		cmd, ok := s.filter(args[2], args[3])
+		if !ok {
+			return "", nil
+		}
+		s.g.Track(args[1], args[2], cmd)
+		return "", nil
+	}},
+	// hint <id> <wd>
+	"hint": {3, func(s *Server, args []string) (string, error) {
+		s.touch(args[1])
+		return s.g.Hint(args[1], args[2]), nil
+	}},
+	// end <id>
+	"end": {2, func(s *Server, args []string) (string, error) {
+		s.g.End(args[1])
+		s.endSession(args[1])
+		return "", nil
+	}},
+	// del <id> <wd> <cmd>
+	"del": {4, func(s *Server, args []string) (string, error) {
+		s.touch(args[1])
+		s.g.Delete(args[1], args[2], args[3])
+		return "", nil
+	}},
+	// incognito <id>
+	"incognito": {2, func(s *Server, args []string) (string, error) {
+		s.setIncognito(args[1])
+		return "", nil
+	}},
+	// prune [dry-run]
+	"prune": {0, func(s *Server, args []string) (string, error) {
+		if len(args) > 2 || (len(args) == 2 && args[1] != "dry-run") {
+			return "", errors.New("wrong usage, expected prune [dry-run]")
+		}
+		result, err := s.Prune(len(args) == 2)
+		if err != nil {
+			return "", err
+		}
+		return result.String(), nil
+	}},
+	// forget [re] [dry-run] <pattern>, the pattern is last as it might be
+	// anything.
+	"forget": {0, func(s *Server, args []string) (string, error) {
+		if len(args) < 2 || len(args) > 4 {
+			return "", errors.New("wrong usage, expected forget [re] [dry-run] <pattern>")
+		}
+		var regex, dryRun bool
+		for _, opt := range args[1 : len(args)-1] {
+			switch opt {
+			case "re":
+				regex = true
+			case "dry-run":
+				dryRun = true
+			default:
+				return "", fmt.Errorf("unknown forget option: %q", opt)
+			}
+		}
+		return s.Forget(args[len(args)-1], regex, dryRun)
+	}},
+	// sessions
+	"sessions": {1, func(s *Server, args []string) (string, error) {
+		return formatSessions(s.Sessions()), nil
+	}},
+	// session <id>
+	"session": {2, func(s *Server, args []string) (string, error) {
+		info, ok := s.Session(args[1])
+		if !ok {
+			return "", fmt.Errorf("unknown session: %q", args[1])
+		}
+		return formatSession(info), nil
+	}},
+	// explain <id> <wd>
+	"explain": {3, func(s *Server, args []string) (string, error) {
+		e, err := s.Explain(args[1], args[2])
+		if err != nil {
+			return "", err
+		}
+		return formatExplanation(e), nil
+	}},
+	// stats [top]
+	"stats": {0, func(s *Server, args []string) (string, error) {
+		if len(args) > 2 {
+			return "", errors.New("wrong usage, expected stats [top]")
+		}
+		top := DefaultStatsTop
+		if len(args) == 2 {
+			var err error
+			if top, err = strconv.Atoi(args[1]); err != nil {
+				return "", fmt.Errorf("invalid number of entries: %q", args[1])
+			}
+		}
+		stats, err := s.Stats(top)
+		if err != nil {
+			return "", err
+		}
+		return stats.String(), nil
+	}},
+	// save-now
+	"save-now": {1, func(s *Server, args []string) (string, error) {
+		if err := s.SaveNow(); err != nil {
+			return "", err
+		}
+		return "saved " + s.cachePath, nil
+	}},
+	// reload [cache]
+	"reload": {0, func(s *Server, args []string) (string, error) {
+		if len(args) > 2 || (len(args) == 2 && args[1] != "cache") {
+			return "", errors.New("wrong usage, expected reload [cache]")
+		}
+		fromCache := len(args) == 2
+		if err := s.Reload(fromCache); err != nil {
+			return "", err
+		}
+		if fromCache {
+			return "reloaded " + s.cachePath, nil
+		}
+		return "reloaded", nil
+	}},
+	// shutdown
+	"shutdown": {1, func(s *Server, args []string) (string, error) {
+		if err := s.Shutdown(); err != nil {
+			return "", err
+		}
+		return "shutting down", nil
+	}},
+	// ping, to check that the server is up
+	"ping": {1, func(s *Server, args []string) (string, error) {
+		return "pong", nil
+	}},
+}
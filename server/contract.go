@@ -1,5 +1,7 @@
 package server
 
+import "github.com/lzambarda/hbt/graph"
+
 // Graph has all the functions a suggestion graph needs to be implemented.
 type Graph interface {
 	// Track adds to the graph the command cmd performed at path wd by the id
@@ -18,3 +20,46 @@ type Graph interface {
 	// Load initialises the graph with a serialiastion at the give file path.
 	Load(filePath string) error
 }
+
+// Filter vets commands before they are tracked.
+type Filter interface {
+	// Filter returns the command to track, which might have been rewritten,
+	// or false if it must not be tracked at all. The rule which matched, if
+	// any, is returned for debugging purposes.
+	Filter(wd, cmd string) (filtered, rule string, ok bool)
+}
+
+// Pruner is implemented by graphs which can forget stale knowledge.
+type Pruner interface {
+	// Prune removes what is deemed stale by opts.
+	Prune(opts graph.PruneOptions) graph.PruneResult
+}
+
+// Forgetter is implemented by graphs which can remove commands everywhere.
+type Forgetter interface {
+	// Forget removes the commands matching match in every directory.
+	Forget(match func(cmd string) bool, dryRun bool) []graph.Forgotten
+	// ForgetFile does the same on a graph saved at filePath.
+	ForgetFile(filePath string, match func(cmd string) bool, dryRun bool) ([]graph.Forgotten, error)
+}
+
+// Inspector is implemented by graphs which can describe their sessions.
+type Inspector interface {
+	// Sessions returns the state of every session known to the graph.
+	Sessions() []graph.SessionState
+	// Session returns the state of session id, or false if it is unknown.
+	Session(id string) (graph.SessionState, bool)
+}
+
+// Explainer is implemented by graphs which can describe how hints are chosen.
+type Explainer interface {
+	// Explain describes what Hint would suggest to session id in wd, without
+	// changing any state.
+	Explain(id, wd string) graph.Explanation
+}
+
+// Statter is implemented by graphs which can describe how much they learned.
+type Statter interface {
+	// Stats describes the graph, listing at most top entries.
+	Stats(top int) graph.Stats
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lzambarda/hbt/graph"
+)
+
+// ErrorPrefix starts the response to a request which failed, it is followed by
+// the error message. It is the ASCII negative acknowledgement character, which
+// cannot be part of a successful response.
+const ErrorPrefix = "\x15"
+
+// SaveNow saves the graph to the cache right away.
+func (s *Server) SaveNow() error {
+	if s.cachePath == "" {
+		return errors.New("no cache to save to")
+	}
+	return s.save()
+}
+
+// SaveHook is called right before the graph is saved. The function it
+// returns, if not nil, is called once the graph is saved, e.g. to persist
+// state which must not get ahead of the cache.
+type SaveHook func() (saved func() error)
+
+// SetSaveHook sets what to do around every save of the graph.
+func (s *Server) SetSaveHook(h SaveHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveHook = h
+}
+
+// SetOwnFiles sets how to list the files shared with other hosts which only
+// this one writes, e.g. the exported sync deltas, for Forget to scrub them.
+func (s *Server) SetOwnFiles(files func() ([]string, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ownFiles = files
+}
+
+func (s *Server) save() error {
+	s.mu.RLock()
+	hook := s.saveHook
+	s.mu.RUnlock()
+	var saved func() error
+	if hook != nil {
+		saved = hook()
+	}
+	slog.Debug("Saving graph", "path", s.cachePath)
+	if err := s.g.Save(s.cachePath); err != nil {
+		return err
+	}
+	if saved != nil {
+		return saved()
+	}
+	return nil
+}
+
+// Config is what can change while the server runs.
+type Config struct {
+	Filters      []Filter
+	PruneOptions graph.PruneOptions
+	// Configure, if not nil, applies new settings to the graph in use, which
+	// is never replaced so that what it learned and anything holding it (e.g.
+	// a syncer) carry on.
+	Configure func(g Graph)
+}
+
+// Reloader returns the configuration the server switches to on reload. It is
+// given the graph in use, to check that it can be configured.
+type Reloader func(current Graph) (Config, error)
+
+// SetReloader sets how the server reloads its configuration.
+func (s *Server) SetReloader(r Reloader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloader = r
+}
+
+// Reload switches to the configuration returned by the reloader, if any, and
+// loads the content of the cache into the graph if fromCache is true,
+// discarding what has been learned since it was last saved.
+// Requests wait for the switch, which happens at once. Nothing changes if the
+// configuration is invalid.
+func (s *Server) Reload(fromCache bool) error {
+	s.swap.Lock()
+	defer s.swap.Unlock()
+	s.mu.RLock()
+	reloader := s.reloader
+	s.mu.RUnlock()
+	if fromCache && s.cachePath == "" {
+		return errors.New("no cache to reload from")
+	}
+	if reloader == nil {
+		if !fromCache {
+			return errors.New("no configuration to reload")
+		}
+		return s.g.Load(s.cachePath)
+	}
+	c, err := reloader(s.g)
+	if err != nil {
+		return err
+	}
+	if fromCache {
+		if err = s.g.Load(s.cachePath); err != nil {
+			return err
+		}
+	}
+	if c.Configure != nil {
+		c.Configure(s.g)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = c.Filters
+	s.pruneOpts = c.PruneOptions
+	return nil
+}
+
+// reloadRoutine reloads the configuration on SIGHUP.
+func (s *Server) reloadRoutine() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			slog.Info("Reloading the configuration")
+			if err := s.Reload(false); err != nil {
+				slog.Error("Cannot reload the configuration", "error", err)
+			}
+		}
+	}()
+}
+
+// Shutdown saves the graph and makes every Serve return once the connections
+// being handled are closed. If Serve has not been called yet, it returns right
+// away when it is.
+func (s *Server) Shutdown() error {
+	if s.cachePath != "" {
+		if err := s.save(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lzambarda/hbt/graph"
+)
+
+// Explain describes what hint would suggest to session id in wd, including the
+// filter rules matching each candidate.
+func (s *Server) Explain(id, wd string) (graph.Explanation, error) {
+	ex, ok := s.g.(Explainer)
+	if !ok {
+		return graph.Explanation{}, fmt.Errorf("graph %T cannot explain hints", s.g)
+	}
+	e := ex.Explain(id, wd)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i, c := range e.Candidates {
+		for _, f := range s.filters {
+			if _, rule, _ := f.Filter(wd, c.Cmd); rule != "" {
+				e.Candidates[i].Filters = append(e.Candidates[i].Filters, rule)
+			}
+		}
+	}
+	return e, nil
+}
+
+func formatExplanation(e graph.Explanation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "directory: %s\n", e.Wd)
+	switch {
+	case e.Matched == "":
+		b.WriteString("matched: nothing\n")
+	case e.Exact():
+		fmt.Fprintf(&b, "matched: %s (exact)\n", e.Matched)
+	default:
+		fmt.Fprintf(&b, "matched: %s (partial, on components %s)\n", e.Matched, strings.Join(e.Components, ", "))
+	}
+	fmt.Fprintf(&b, "suggestion cursor: %d\n", e.Cursor)
+	fmt.Fprintf(&b, "candidates (%d):", len(e.Candidates))
+	for i, c := range e.Candidates {
+		marker := " "
+		if i == e.Next {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "\n%s [%d] %s (score %d)", marker, i, c.Cmd, c.Score)
+		if len(c.Filters) > 0 {
+			fmt.Fprintf(&b, " matched by filters %s", strings.Join(c.Filters, ", "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/lzambarda/hbt/cache"
+	"github.com/lzambarda/hbt/graph"
+	"github.com/lzambarda/hbt/internal"
+)
+
+// Forget removes the commands containing pattern, or matching it if regex is
+// true, from the graph and its backups. The cache is saved right away, so
+// that the next save does not turn the current content into a backup.
+// The corrupt caches moved aside by cache.Read cannot be read, so they are
+// deleted if they cannot be scrubbed like the backups. The files listed by
+// SetOwnFiles are scrubbed too, as other hosts would import them otherwise.
+// It returns a report of what was removed.
+func (s *Server) Forget(pattern string, regex, dryRun bool) (string, error) {
+	f, ok := s.g.(Forgetter)
+	if !ok {
+		return "", fmt.Errorf("graph %T cannot forget commands", s.g)
+	}
+	if pattern == "" {
+		return "", errors.New("empty pattern")
+	}
+	match := func(cmd string) bool {
+		return strings.Contains(cmd, pattern)
+	}
+	if regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		match = re.MatchString
+	}
+
+	verb := "forgot"
+	if dryRun {
+		verb = "would forget"
+	}
+	var report strings.Builder
+	forgotten := f.Forget(match, dryRun)
+	writeForgotten(&report, verb, "", forgotten)
+	if s.cachePath == "" {
+		return strings.TrimSuffix(report.String(), "\n"), nil
+	}
+	if !dryRun && len(forgotten) > 0 {
+		if err := s.save(); err != nil {
+			return "", err
+		}
+	}
+	for n := 1; n <= internal.Backups; n++ {
+		backup := cache.BackupPath(s.cachePath, n)
+		forgotten, err := f.ForgetFile(backup, match, dryRun)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		writeForgotten(&report, verb, backup, forgotten)
+	}
+	quarantined, err := cache.Quarantined(s.cachePath)
+	if err != nil {
+		return "", err
+	}
+	for _, filePath := range quarantined {
+		forgotten, err := f.ForgetFile(filePath, match, dryRun)
+		if err == nil {
+			writeForgotten(&report, verb, filePath, forgotten)
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(&report, "would delete %s, which is corrupt\n", filePath)
+			continue
+		}
+		if err = os.Remove(filePath); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&report, "deleted %s, which is corrupt\n", filePath)
+	}
+	s.mu.RLock()
+	ownFiles := s.ownFiles
+	s.mu.RUnlock()
+	if ownFiles == nil {
+		return strings.TrimSuffix(report.String(), "\n"), nil
+	}
+	files, err := ownFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, filePath := range files {
+		forgotten, err := f.ForgetFile(filePath, match, dryRun)
+		if err != nil {
+			// Old deltas are deleted while syncing.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		if len(forgotten) > 0 {
+			writeForgotten(&report, verb, filePath, forgotten)
+		}
+	}
+	return strings.TrimSuffix(report.String(), "\n"), nil
+}
+
+func writeForgotten(b *strings.Builder, verb, backup string, forgotten []graph.Forgotten) {
+	where := ""
+	if backup != "" {
+		where = " in " + backup
+	}
+	fmt.Fprintf(b, "%s %d commands%s\n", verb, len(forgotten), where)
+	for _, f := range forgotten {
+		fmt.Fprintf(b, "  %s: %s (%d hits)\n", f.Wd, f.Cmd, f.Hits)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lzambarda/hbt/graph"
+)
+
+// SessionInfo is what the server and its graph know about a session.
+type SessionInfo struct {
+	graph.SessionState
+	// Zero if the session has not been active since the server started.
+	LastActivity time.Time
+	Incognito    bool
+}
+
+// Sessions returns every session known to the server or its graph, sorted by
+// id.
+func (s *Server) Sessions() []SessionInfo {
+	infos := map[string]*SessionInfo{}
+	if in, ok := s.g.(Inspector); ok {
+		for _, state := range in.Sessions() {
+			infos[state.ID] = &SessionInfo{SessionState: state}
+		}
+	}
+	s.mu.RLock()
+	for id, ss := range s.sessions {
+		info, ok := infos[id]
+		if !ok {
+			info = &SessionInfo{SessionState: graph.SessionState{ID: id}}
+			infos[id] = info
+		}
+		info.LastActivity = ss.lastActivity
+		info.Incognito = ss.incognito
+	}
+	s.mu.RUnlock()
+	sessions := make([]SessionInfo, 0, len(infos))
+	for _, info := range infos {
+		sessions = append(sessions, *info)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+// Session returns what is known about session id, or false if nothing is.
+func (s *Server) Session(id string) (SessionInfo, bool) {
+	info := SessionInfo{SessionState: graph.SessionState{ID: id}}
+	found := false
+	if in, ok := s.g.(Inspector); ok {
+		info.SessionState, found = in.Session(id)
+		info.ID = id
+	}
+	s.mu.RLock()
+	if ss, ok := s.sessions[id]; ok {
+		info.LastActivity = ss.lastActivity
+		info.Incognito = ss.incognito
+		found = true
+	}
+	s.mu.RUnlock()
+	return info, found
+}
+
+// formatSessions returns one line per session.
+func formatSessions(sessions []SessionInfo) string {
+	if len(sessions) == 0 {
+		return "no sessions"
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLAST ACTIVITY\tCOMMANDS\tCURSOR\tINCOGNITO\tLAST COMMAND")
+	for _, info := range sessions {
+		last := ""
+		if len(info.History) > 0 {
+			last = info.History[0].Wd + ": " + info.History[0].Cmd
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%t\t%s\n",
+			info.ID, formatActivity(info.LastActivity), len(info.History), info.Cursor, info.Incognito, last)
+	}
+	w.Flush() //nolint:errcheck // It is okay.
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatSession describes a session, including its whole history.
+func formatSession(info SessionInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "session %s\n", info.ID)
+	fmt.Fprintf(&b, "last activity: %s\n", formatActivity(info.LastActivity))
+	fmt.Fprintf(&b, "incognito: %t\n", info.Incognito)
+	fmt.Fprintf(&b, "suggestion cursor: %d\n", info.Cursor)
+	fmt.Fprintf(&b, "history (%d commands, most recent first):", len(info.History))
+	for i, step := range info.History {
+		fmt.Fprintf(&b, "\n  %d. %s: %s", i+1, step.Wd, step.Cmd)
+	}
+	return b.String()
+}
+
+func formatActivity(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), now().Sub(t).Truncate(time.Second))
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MaxRequestSize is the largest request the server reads, in bytes. Commands
+// and directories are nowhere near it.
+const MaxRequestSize = 1 << 20
+
+// ErrRequestTooLarge is returned for a request longer than MaxRequestSize.
+var ErrRequestTooLarge = errors.New("request too large")
+
+// EncodeRequest frames the command and arguments of a request as netstrings:
+// each one is prefixed with its length in bytes and a colon, and followed by a
+// comma, so that they can hold any byte, newlines included.
+func EncodeRequest(args []string) []byte {
+	var b bytes.Buffer
+	for _, arg := range args {
+		b.WriteString(strconv.Itoa(len(arg)))
+		b.WriteByte(':')
+		b.WriteString(arg)
+		b.WriteByte(',')
+	}
+	return b.Bytes()
+}
+
+// DecodeRequest is the opposite of EncodeRequest. A request which does not
+// start with a length is split on newlines, the way older clients sent them,
+// which is still handy to talk to the server by hand. No command starts with
+// a digit, so they cannot be mistaken for each other.
+func DecodeRequest(b []byte) ([]string, error) {
+	if len(b) == 0 || b[0] < '0' || b[0] > '9' {
+		return strings.Split(string(b), "\n"), nil
+	}
+	var args []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, ':')
+		if i < 0 {
+			return nil, errors.New("malformed request: missing length")
+		}
+		n, err := strconv.Atoi(string(b[:i]))
+		// Bound the length before adding to it, so that a huge one cannot
+		// overflow.
+		if err != nil || n < 0 || n > len(b)-i-2 || b[i+1+n] != ',' {
+			return nil, errors.New("malformed request: invalid length")
+		}
+		args = append(args, string(b[i+1:i+1+n]))
+		b = b[i+2+n:]
+	}
+	return args, nil
+}
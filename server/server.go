@@ -2,77 +2,246 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/lzambarda/hbt/graph"
 	"github.com/lzambarda/hbt/internal"
 )
 
-func saveRoutines(g Graph, cachePath string) {
+func (s *Server) saveRoutines() {
 	// Intercept termination signal to save the most recent knowledge
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-c
-		if internal.Debug {
-			fmt.Println("Saving graph at", cachePath)
-		}
-		err := g.Save(cachePath)
-		if err != nil {
-			fmt.Println(err)
+		sig := <-c
+		slog.Info("Shutting down", "signal", sig.String())
+		if err := s.Shutdown(); err != nil {
+			slog.Error("Cannot shut down", "error", err)
 			os.Exit(1)
 		}
-		os.Exit(0)
 	}()
 	// Periodically save the file
 	go func() {
 		for {
 			time.Sleep(internal.SaveInterval)
-			if internal.Debug {
-				fmt.Println("Saving graph at", cachePath)
-			}
-			err := g.Save(cachePath)
+			s.swap.RLock()
+			err := s.save()
+			s.swap.RUnlock()
 			if err != nil {
-				fmt.Println(err)
+				slog.Error("Cannot save graph", "path", s.cachePath, "error", err)
 				os.Exit(1)
 			}
 		}
 	}()
 }
 
-// Start the hbt server with the given graph and cache path.
-func Start(g Graph, cachePath string) error {
-	saveRoutines(g, cachePath)
-	if internal.Debug {
-		fmt.Println("Starting server at", internal.Port)
+// Server answers the requests of the shell integrations.
+type Server struct {
+	g         Graph
+	cachePath string
+	filters   []Filter
+	sessions  map[string]*session
+	pruneOpts graph.PruneOptions
+	reloader  Reloader
+	saveHook  SaveHook
+	// Lists the files shared with other hosts which only this one writes.
+	ownFiles func() ([]string, error)
+	// Held for reading while using g, and for writing to reload it, so that
+	// requests do not see half of a reload.
+	swap      sync.RWMutex
+	listeners []net.Listener
+	// Connections being handled, waited for on shutdown.
+	conns  sync.WaitGroup
+	active int
+	// When the last connection was accepted or closed.
+	lastActivity time.Time
+	shutdown     bool
+	mu           sync.RWMutex
+}
+
+// New returns a Server for graph g, which is saved at cachePath.
+func New(g Graph, cachePath string) *Server {
+	return &Server{
+		g:         g,
+		cachePath: cachePath,
+		sessions:  map[string]*session{},
+	}
+}
+
+// SetFilters replaces the filters applied to every command before it is
+// tracked. They are applied in order.
+func (s *Server) SetFilters(filters ...Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = filters
+}
+
+// SetPruneOptions replaces the rules used by the prune command and the
+// periodic prune.
+func (s *Server) SetPruneOptions(opts graph.PruneOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneOpts = opts
+}
+
+// Prune removes the stale knowledge of the graph, if it supports it.
+func (s *Server) Prune(dryRun bool) (graph.PruneResult, error) {
+	p, ok := s.g.(Pruner)
+	if !ok {
+		return graph.PruneResult{}, fmt.Errorf("graph %T cannot be pruned", s.g)
+	}
+	s.mu.RLock()
+	opts := s.pruneOpts
+	s.mu.RUnlock()
+	opts.DryRun = dryRun
+	return p.Prune(opts), nil
+}
+
+func (s *Server) pruneRoutine() {
+	if internal.PruneInterval <= 0 {
+		return
+	}
+	if _, ok := s.g.(Pruner); !ok {
+		return
 	}
-	l, err := net.Listen("tcp4", ":"+internal.Port)
+	go func() {
+		for {
+			time.Sleep(internal.PruneInterval)
+			s.swap.RLock()
+			result, err := s.Prune(false)
+			s.swap.RUnlock()
+			if err != nil {
+				slog.Error("Cannot prune graph", "error", err)
+				continue
+			}
+			slog.Info("Pruned graph", "nodes", result.Nodes, "edges", result.Edges)
+		}
+	}()
+}
+
+// Start the hbt server. It serves the listeners passed by the service manager
+// if any (see activationListeners), otherwise it listens on internal.Port of
+// the loopback interface.
+func (s *Server) Start() error {
+	s.saveRoutines()
+	s.pruneRoutine()
+	s.expireRoutine()
+	s.reloadRoutine()
+	ls, err := activationListeners()
 	if err != nil {
 		return err
 	}
+	if len(ls) == 0 {
+		slog.Info("Starting server", "port", internal.Port)
+		l, err := net.Listen("tcp4", internal.Host+":"+internal.Port)
+		if err != nil {
+			return err
+		}
+		ls = append(ls, l)
+	} else {
+		slog.Info("Starting server with the listeners of the service manager", "listeners", len(ls))
+	}
+	s.idleRoutine(internal.IdleExit)
+	errs := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) {
+			errs <- s.Serve(l)
+		}(l)
+	}
+	for range ls {
+		if err = <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serve answers the requests received on l until Shutdown is called. It can
+// be called for several listeners at once.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return l.Close()
+	}
+	s.listeners = append(s.listeners, l)
+	s.lastActivity = now()
+	s.mu.Unlock()
 	defer l.Close() //nolint:errcheck // It is okay.
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			s.mu.RLock()
+			shutdown := s.shutdown
+			s.mu.RUnlock()
+			if shutdown {
+				s.conns.Wait()
+				return nil
+			}
 			return err
 		}
-		go handleConnection(c, g)
+		s.conns.Add(1)
+		s.setActive(1)
+		go func() {
+			defer s.conns.Done()
+			defer s.setActive(-1)
+			s.handleConnection(c)
+		}()
 	}
 }
 
+func (s *Server) setActive(delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active += delta
+	s.lastActivity = now()
+}
+
+// idleRoutine shuts the server down once it has not handled any connection
+// for idle, 0 disables it. This is meant for socket activation, where the
+// service manager starts the server again on the next connection.
+func (s *Server) idleRoutine(idle time.Duration) {
+	if idle <= 0 {
+		return
+	}
+	interval := idle / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			s.mu.RLock()
+			idling := s.active == 0 && now().Sub(s.lastActivity) >= idle
+			s.mu.RUnlock()
+			if !idling {
+				continue
+			}
+			slog.Info("Shutting down once idle", "idle", idle)
+			if err := s.Shutdown(); err != nil {
+				slog.Error("Cannot shut down", "error", err)
+			}
+			return
+		}
+	}()
+}
+
 // Stop the server.
 func Stop() error {
 	return nil
 }
 
-func handleConnection(c net.Conn, g Graph) {
+func (s *Server) handleConnection(c net.Conn) {
 	defer c.Close()              //nolint:errcheck // It is okay.
 	buf := make([]byte, 0, 4096) // arbitrary
 	tmp := make([]byte, 64)      // arbitrary
@@ -80,61 +249,87 @@ func handleConnection(c net.Conn, g Graph) {
 		n, err := c.Read(tmp)
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
-				fmt.Println(err)
+				slog.Warn("Cannot read request", "error", err)
 				return
 			}
 			break
 		}
 		buf = append(buf, tmp[:n]...)
+		if len(buf) > MaxRequestSize {
+			slog.Warn("Cannot read request", "error", ErrRequestTooLarge)
+			c.Write([]byte(ErrorPrefix + ErrRequestTooLarge.Error())) //nolint:errcheck,gosec // It is okay.
+			return
+		}
 	}
-	args := strings.Split(string(buf), "\n")
-	if internal.Debug {
-		fmt.Println(args)
-	}
-	result, err := ProcessCommand(args, g)
+	start := time.Now()
+	args, err := DecodeRequest(buf)
 	if err != nil {
-		fmt.Println(err)
+		slog.Warn("Cannot decode request", "error", err)
+		c.Write([]byte(ErrorPrefix + err.Error())) //nolint:errcheck,gosec // It is okay.
 		return
 	}
+	result, err := s.ProcessCommand(args)
+	attrs := requestAttrs(args)
+	attrs = append(attrs, slog.Duration("latency", time.Since(start)))
+	if err != nil {
+		attrs = append(attrs, slog.String("result", "error"), slog.Any("error", err))
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "Request failed", attrs...)
+		result = ErrorPrefix + err.Error()
+	} else {
+		attrs = append(attrs, slog.String("result", "ok"))
+		slog.LogAttrs(context.Background(), slog.LevelDebug, "Request", attrs...)
+	}
 	if result != "" {
 		_, err = c.Write([]byte(result))
 		if err != nil {
-			fmt.Println(err)
+			slog.Warn("Cannot write response", "command", args[0], "error", err)
 		}
 	}
 }
 
-// ProcessCommand processes the arguments and runs a command on the given Graph.
-func ProcessCommand(args []string, g Graph) (result string, err error) {
+// requestAttrs describes a request for the logs, without its other arguments
+// as commands might hold secrets.
+func requestAttrs(args []string) []slog.Attr {
+	attrs := []slog.Attr{slog.String("command", args[0])}
+	if sessionCommands[args[0]] && len(args) > 1 {
+		attrs = append(attrs, slog.String("session", args[1]))
+	}
+	return attrs
+}
+
+// ProcessCommand processes the arguments and runs a command on the graph.
+func (s *Server) ProcessCommand(args []string) (result string, err error) {
 	if len(args) == 0 {
 		return "", errors.New("missing command")
 	}
+	c, ok := commands[args[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown command: %q", args[0])
+	}
+	if c.args > 0 && len(args) != c.args {
+		return "", fmt.Errorf("wrong number of arguments, expected %d, got %d", c.args, len(args))
+	}
+	if !swapping[args[0]] {
+		s.swap.RLock()
+		defer s.swap.RUnlock()
+	}
+	return c.run(s, args)
+}
 
-	switch args[0] {
-	case "track":
-		if len(args) != 4 {
-			return "", fmt.Errorf("wrong number of arguments, expected 4, got %d", len(args))
-		}
-		g.Track(args[1], args[2], args[3])
-	case "hint":
-		if len(args) != 3 {
-			return "", fmt.Errorf("wrong number of arguments, expected 3, got %d", len(args))
+// filter runs cmd through the filters, returning false if it must not be
+// tracked.
+func (s *Server) filter(wd, cmd string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.filters {
+		filtered, rule, ok := f.Filter(wd, cmd)
+		if rule != "" {
+			slog.Debug("Filter rule matched", "rule", rule, "tracked", ok)
 		}
-		// args[3] is unused for now
-		hint := g.Hint(args[1], args[2]) //nolint:errcheck,gosec // It is okay.
-		return hint, nil
-	case "end":
-		if len(args) != 2 {
-			return "", fmt.Errorf("wrong number of arguments, expected 2, got %d", len(args))
+		if !ok {
+			return "", false
 		}
-		g.End(args[1])
-	case "del":
-		if len(args) != 4 {
-			return "", fmt.Errorf("wrong number of arguments, expected 4, got %d", len(args))
-		}
-		g.Delete(args[1], args[2], args[3])
-	default:
-		return "", fmt.Errorf("unknown command: %q", args[0])
+		cmd = filtered
 	}
-	return "", nil
+	return cmd, true
 }
@@ -0,0 +1,403 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/lzambarda/hbt/cache"
+	"github.com/lzambarda/hbt/graph"
+	"github.com/lzambarda/hbt/graph/naive"
+	"github.com/lzambarda/hbt/syncdir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const shrug = "¯\\_(ツ)_/¯"
+
+func TestServer(t *testing.T) {
+	t.Run("Filters", testServerFilters)
+	t.Run("Incognito", testServerIncognito)
+	t.Run("Forget", testServerForget)
+	t.Run("Expire", testServerExpire)
+	t.Run("Sessions", testServerSessions)
+	t.Run("Explain", testServerExplain)
+	t.Run("Stats", testServerStats)
+	t.Run("Reload", testServerReload)
+	t.Run("ReloadSync", testServerReloadSync)
+	t.Run("Request", testServerRequest)
+	t.Run("SaveHook", testServerSaveHook)
+}
+
+type dropFilter string
+
+func (d dropFilter) Filter(_, cmd string) (filtered, rule string, ok bool) {
+	if cmd == string(d) {
+		return "", "drop", false
+	}
+	return cmd + "!", "", true
+}
+
+func run(t *testing.T, s *Server, args ...string) string {
+	t.Helper()
+	result, err := s.ProcessCommand(args)
+	require.NoError(t, err)
+	return result
+}
+
+func testServerFilters(t *testing.T) {
+	s := New(naive.NewGraph(10, 3), "")
+	s.SetFilters(dropFilter("secret"))
+	run(t, s, "track", "1", "/d", "secret")
+	assert.Equal(t, shrug, run(t, s, "hint", "1", "/d"))
+	run(t, s, "track", "1", "/d", "make")
+	assert.Equal(t, "make!", run(t, s, "hint", "1", "/d"))
+}
+
+func testServerIncognito(t *testing.T) {
+	s := New(naive.NewGraph(10, 3), "")
+	run(t, s, "track", "1", "/d", "make")
+	run(t, s, "track", "1", "/d", "make")
+	run(t, s, "incognito", "1")
+	run(t, s, "track", "1", "/d", "ls")
+	run(t, s, "track", "2", "/d", "go test")
+	assert.Equal(t, "make", run(t, s, "hint", "1", "/d"), "hints still work")
+	assert.Equal(t, "go test", run(t, s, "hint", "1", "/d"), "other sessions are tracked")
+	assert.Equal(t, "make", run(t, s, "hint", "1", "/d"), "ls was not tracked")
+
+	run(t, s, "end", "1")
+	for i := 0; i < 3; i++ {
+		run(t, s, "track", "1", "/d", "ls")
+	}
+	assert.Equal(t, "ls", run(t, s, "hint", "3", "/d"), "tracked again after end")
+
+	_, err := s.ProcessCommand([]string{"incognito"})
+	assert.Error(t, err)
+}
+
+func testServerForget(t *testing.T) {
+	cachePath := path.Join(t.TempDir(), "cache")
+	g := naive.NewGraph(10, 3)
+	s := New(g, cachePath)
+	run(t, s, "track", "1", "/a", "mysql -pHunter2")
+	run(t, s, "track", "1", "/b", "mysql -pHunter2 db")
+	require.NoError(t, g.Save(cachePath))
+	run(t, s, "track", "1", "/b", "make")
+	require.NoError(t, g.Save(cachePath))
+
+	corrupt := cachePath + ".corrupt-20240101T000000"
+	require.NoError(t, os.WriteFile(corrupt, []byte("mysql -pHunter2"), 0o600))
+
+	delta := naive.NewGraph(10, 3)
+	delta.Track("1", "/c", "mysql -pHunter2")
+	deltaPath := path.Join(t.TempDir(), "delta")
+	require.NoError(t, delta.Save(deltaPath))
+	s.SetOwnFiles(func() ([]string, error) {
+		return []string{deltaPath, deltaPath + ".deleted"}, nil
+	})
+
+	report := run(t, s, "forget", "dry-run", "Hunter2")
+	assert.Contains(t, report, "would forget 2 commands\n")
+	assert.Contains(t, report, "/a: mysql -pHunter2 (1 hits)")
+	assert.Contains(t, report, "would forget 2 commands in "+cache.BackupPath(cachePath, 1))
+	assert.Equal(t, "mysql -pHunter2", run(t, s, "hint", "1", "/a"), "dry run")
+	assert.Contains(t, report, "would delete "+corrupt)
+	assert.FileExists(t, corrupt)
+	assert.Contains(t, report, "would forget 1 commands in "+deltaPath)
+
+	report = run(t, s, "forget", "re", `^mysql .*-p\S+$`)
+	assert.Contains(t, report, "forgot 1 commands\n")
+	assert.Contains(t, report, "/a: mysql -pHunter2 (1 hits)")
+	assert.NotContains(t, report, "db")
+	assert.Equal(t, shrug, run(t, s, "hint", "1", "/a"))
+	assert.Contains(t, report, "deleted "+corrupt)
+	assert.NoFileExists(t, corrupt, "it cannot be scrubbed")
+	assert.Contains(t, report, "forgot 1 commands in "+deltaPath)
+
+	// Neither the cache, the backups nor the delta know about it anymore.
+	for filePath, wd := range map[string]string{cachePath: "/a", cache.BackupPath(cachePath, 1): "/a", cache.BackupPath(cachePath, 2): "/a", deltaPath: "/c"} {
+		saved := naive.NewGraph(10, 3)
+		require.NoError(t, saved.LoadStrict(filePath))
+		assert.NotEqual(t, "mysql -pHunter2", saved.Hint("1", wd), filePath)
+	}
+
+	_, err := s.ProcessCommand([]string{"forget", "nope", "x"})
+	assert.Error(t, err)
+	_, err = s.ProcessCommand([]string{"forget", "re", "("})
+	assert.Error(t, err)
+}
+
+func testServerExpire(t *testing.T) {
+	start := time.Now()
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+	s := New(naive.NewGraph(10, 3), "")
+	run(t, s, "track", "idle", "/d", "make")
+	run(t, s, "track", "idle", "/d", "make")
+	run(t, s, "track", "idle", "/d", "ls")
+	assert.Equal(t, "make", run(t, s, "hint", "idle", "/d"))
+	run(t, s, "incognito", "private")
+
+	now = func() time.Time { return start.Add(time.Hour) }
+	run(t, s, "hint", "active", "/d")
+	assert.Empty(t, s.expireSessions(2*time.Hour))
+	assert.ElementsMatch(t, []string{"idle", "private"}, s.expireSessions(30*time.Minute))
+	assert.Contains(t, s.sessions, "active")
+	assert.NotContains(t, s.sessions, "idle")
+	assert.Equal(t, "make", run(t, s, "hint", "idle", "/d"), "suggestion cursor was reset")
+
+	assert.Empty(t, s.expireSessions(30*time.Minute), "incognito sessions are ended once")
+
+	run(t, s, "track", "private", "/d", "secret")
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, "secret", run(t, s, "hint", "private", "/d"), "still incognito")
+	}
+
+	now = func() time.Time { return start.Add(time.Hour + 4*time.Hour) }
+	assert.Contains(t, s.expireSessions(time.Hour), "private")
+	now = func() time.Time { return start.Add(time.Hour + 30*24*time.Hour) }
+	assert.NotContains(t, s.expireSessions(time.Hour), "private")
+	assert.Contains(t, s.sessions, "private", "incognito until it ends")
+	run(t, s, "track", "private", "/d", "secret")
+	run(t, s, "track", "private", "/d", "secret")
+	assert.NotEqual(t, "secret", run(t, s, "hint", "private", "/d"), "still incognito")
+
+	run(t, s, "end", "private")
+	assert.NotContains(t, s.sessions, "private")
+}
+
+func testServerSessions(t *testing.T) {
+	start := time.Now()
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+	s := New(naive.NewGraph(10, 3), "")
+	assert.Equal(t, "no sessions", run(t, s, "sessions"))
+
+	run(t, s, "track", "1", "/a", "make")
+	run(t, s, "track", "1", "/b", "go test")
+	run(t, s, "hint", "1", "/b")
+	run(t, s, "incognito", "2")
+
+	sessions := s.Sessions()
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "1", sessions[0].ID)
+	assert.Equal(t, []graph.Step{{Wd: "/b", Cmd: "go test"}, {Wd: "/a", Cmd: "make"}}, sessions[0].History)
+	assert.Equal(t, 1, sessions[0].Cursor)
+	assert.Equal(t, start, sessions[0].LastActivity)
+	assert.True(t, sessions[1].Incognito)
+
+	now = func() time.Time { return start.Add(time.Minute) }
+	list := run(t, s, "sessions")
+	assert.Contains(t, list, "(1m0s ago)")
+	assert.Contains(t, list, "/b: go test")
+
+	detail := run(t, s, "session", "1")
+	assert.Contains(t, detail, "suggestion cursor: 1\n")
+	assert.Contains(t, detail, "history (2 commands, most recent first):\n  1. /b: go test\n  2. /a: make")
+
+	run(t, s, "end", "1")
+	_, err := s.ProcessCommand([]string{"session", "1"})
+	assert.Error(t, err)
+}
+
+func testServerExplain(t *testing.T) {
+	s := New(naive.NewGraph(10, 2), "")
+	run(t, s, "track", "1", "/a/project", "make")
+	run(t, s, "track", "1", "/a/project", "make")
+	run(t, s, "track", "1", "/a/project", "ls")
+	run(t, s, "track", "1", "/a/project", "go test")
+	run(t, s, "hint", "1", "/a/project")
+	s.SetFilters(dropFilter("ls"))
+
+	e, err := s.Explain("1", "/a/project")
+	require.NoError(t, err)
+	assert.True(t, e.Exact())
+	assert.Equal(t, []graph.Candidate{
+		{Cmd: "make", Score: 2},
+		{Cmd: "go test", Score: 1},
+		{Cmd: "ls", Score: 1, Filters: []string{"drop"}},
+	}, e.Candidates)
+	assert.Equal(t, 1, e.Cursor)
+	assert.Equal(t, 1, e.Next)
+	assert.Equal(t, "go test", run(t, s, "hint", "1", "/a/project"), "explain does not move the cursor")
+
+	e, err = s.Explain("2", "/home/a/project")
+	require.NoError(t, err)
+	assert.False(t, e.Exact())
+	assert.Equal(t, "/a/project", e.Matched)
+	assert.Equal(t, []string{"a", "project"}, e.Components)
+
+	explained := run(t, s, "explain", "2", "/home/a/project")
+	assert.Contains(t, explained, "matched: /a/project (partial, on components a, project)\n")
+	assert.Contains(t, explained, "> [0] make (score 2)")
+	assert.Contains(t, explained, "  [2] ls (score 1) matched by filters drop")
+	assert.Contains(t, run(t, s, "explain", "2", "/nowhere"), "matched: nothing\n")
+}
+
+func testServerStats(t *testing.T) {
+	cachePath := path.Join(t.TempDir(), "cache")
+	g := naive.NewGraph(10, 3)
+	s := New(g, cachePath)
+	for i := 0; i < 3; i++ {
+		run(t, s, "track", "1", "/a", "make")
+	}
+	run(t, s, "track", "1", "/a", "ls")
+	run(t, s, "track", "2", "/b", "ls")
+	run(t, s, "track", "2", "/b", "ls")
+	run(t, s, "track", "2", "/c", "vim")
+
+	stats, err := s.Stats(2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Directories)
+	assert.Equal(t, 4, stats.Commands)
+	assert.Equal(t, 7, stats.Hits)
+	assert.Equal(t, 2, stats.Sessions)
+	assert.Zero(t, stats.CacheSize, "not saved yet")
+	assert.Equal(t, []graph.CommandStats{{Cmd: "ls", Hits: 3}, {Cmd: "make", Hits: 3}}, stats.TopCommands)
+	assert.Equal(t, []graph.DirectoryStats{
+		{Wd: "/a", Hits: 4, Commands: 2, Top: []graph.CommandStats{{Cmd: "make", Hits: 3}, {Cmd: "ls", Hits: 1}}},
+		{Wd: "/b", Hits: 2, Commands: 1, Top: []graph.CommandStats{{Cmd: "ls", Hits: 2}}},
+	}, stats.TopDirectories)
+
+	require.NoError(t, g.Save(cachePath))
+	report := run(t, s, "stats", "1")
+	assert.Contains(t, report, "directories: 3\n")
+	assert.Regexp(t, `cache size: \d+ B\n`, report)
+	assert.Contains(t, report, "top commands:\n  3\tls\nmost active directories:\n  4\t/a (2 commands)\n    3\tmake")
+
+	_, err = s.ProcessCommand([]string{"stats", "many"})
+	assert.Error(t, err)
+	_, err = s.ProcessCommand([]string{"stats", "-1"})
+	assert.EqualError(t, err, "invalid number of entries: -1")
+	_, err = s.Stats(-1)
+	assert.Error(t, err, "whatever the caller")
+	assert.Empty(t, g.Stats(-1).TopDirectories)
+	assert.Equal(t, "1.5 KiB", formatSize(1536))
+}
+
+func testServerReload(t *testing.T) {
+	cachePath := path.Join(t.TempDir(), "cache")
+	s := New(naive.NewGraph(10, 3), cachePath)
+	_, err := s.ProcessCommand([]string{"reload"})
+	assert.EqualError(t, err, "no configuration to reload")
+	run(t, s, "track", "1", "/d", "make")
+	run(t, s, "save-now")
+	run(t, s, "track", "1", "/d", "ls")
+	assert.Equal(t, "reloaded "+cachePath, run(t, s, "reload", "cache"))
+	assert.Equal(t, "make", run(t, s, "hint", "2", "/d"))
+	assert.Equal(t, "make", run(t, s, "hint", "2", "/d"), "ls was not saved")
+
+	var reloads int
+	s.SetReloader(func(current Graph) (Config, error) {
+		reloads++
+		if reloads == 2 {
+			return Config{}, errors.New("invalid configuration")
+		}
+		return Config{
+			Filters: []Filter{dropFilter("secret")},
+			Configure: func(g Graph) {
+				g.(*naive.Graph).Configure(naive.NewGraph(5, 2))
+			},
+		}, nil
+	})
+	// Requests keep being answered while reloading.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			run(t, s, "hint", "3", "/d")
+		}
+	}()
+	assert.Equal(t, "reloaded", run(t, s, "reload"))
+	<-done
+	run(t, s, "track", "1", "/d", "secret")
+	run(t, s, "track", "1", "/d", "go test")
+	hints := []string{run(t, s, "hint", "4", "/d"), run(t, s, "hint", "4", "/d"), run(t, s, "hint", "4", "/d")}
+	assert.Equal(t, []string{"go test!", "make", "go test!"}, hints, "knowledge is carried over and the new filters apply")
+	assert.Equal(t, 2, s.g.(*naive.Graph).MinCommonPath, "the graph is configured in place")
+
+	_, err = s.ProcessCommand([]string{"reload"})
+	assert.EqualError(t, err, "invalid configuration")
+	run(t, s, "track", "1", "/d", "secret")
+	assert.Equal(t, "go test!", run(t, s, "hint", "5", "/d"), "a failed reload changes nothing")
+	assert.Equal(t, "make", run(t, s, "hint", "5", "/d"))
+	assert.Equal(t, "go test!", run(t, s, "hint", "5", "/d"))
+
+	assert.Equal(t, "reloaded "+cachePath, run(t, s, "reload", "cache"))
+	assert.Equal(t, "make", run(t, s, "hint", "6", "/d"))
+	assert.Equal(t, "make", run(t, s, "hint", "6", "/d"), "go test! was not saved")
+	_, err = s.ProcessCommand([]string{"reload", "now"})
+	assert.Error(t, err)
+}
+
+func testServerReloadSync(t *testing.T) {
+	shared := t.TempDir()
+	newHost := func(name string) (*Server, *syncdir.Syncer) {
+		g := naive.NewGraph(10, 3)
+		sy, err := syncdir.New(g, shared, name, path.Join(t.TempDir(), "state"))
+		require.NoError(t, err)
+		s := New(g, path.Join(t.TempDir(), "cache"))
+		s.SetReloader(func(Graph) (Config, error) {
+			return Config{Configure: func(g Graph) {
+				g.(*naive.Graph).Configure(naive.NewGraph(5, 2))
+			}}, nil
+		})
+		return s, sy
+	}
+	a, syncA := newHost("laptop")
+	b, syncB := newHost("devbox")
+
+	run(t, a, "save-now")
+	run(t, a, "reload")
+	run(t, a, "reload", "cache")
+	run(t, a, "track", "1", "/repo", "make")
+	require.NoError(t, syncA.Sync())
+	require.NoError(t, syncB.Sync())
+	assert.Equal(t, "make", run(t, b, "hint", "1", "/repo"), "tracks after a reload are exported")
+
+	run(t, b, "reload")
+	run(t, b, "track", "2", "/repo", "go test")
+	run(t, b, "track", "2", "/repo", "go test")
+	require.NoError(t, syncB.Sync())
+	require.NoError(t, syncA.Sync())
+	assert.Equal(t, "go test", run(t, a, "hint", "3", "/repo"), "imports after a reload reach the graph in use")
+}
+
+func testServerRequest(t *testing.T) {
+	args := []string{"track", "1", "/d", "echo a\necho b", ""}
+	b := EncodeRequest(args)
+	assert.Equal(t, "5:track,1:1,2:/d,13:echo a\necho b,0:,", string(b))
+	decoded, err := DecodeRequest(b)
+	require.NoError(t, err)
+	assert.Equal(t, args, decoded)
+
+	decoded, err = DecodeRequest([]byte("hint\n1\n/d"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hint", "1", "/d"}, decoded, "newline separated")
+
+	for _, malformed := range []string{"5:track", "5:track;", "9:track,", "5", "1:a,2", "9223372036854775807:x,", "18446744073709551617:x,"} {
+		_, err = DecodeRequest([]byte(malformed))
+		assert.Error(t, err, malformed)
+	}
+}
+
+func testServerSaveHook(t *testing.T) {
+	cachePath := path.Join(t.TempDir(), "cache")
+	s := New(naive.NewGraph(10, 3), cachePath)
+	var calls []string
+	s.SetSaveHook(func() func() error {
+		_, err := os.Stat(cachePath)
+		calls = append(calls, fmt.Sprint("before, saved: ", err == nil))
+		return func() error {
+			_, err := os.Stat(cachePath)
+			calls = append(calls, fmt.Sprint("after, saved: ", err == nil))
+			return errors.New("cannot persist")
+		}
+	})
+	_, err := s.ProcessCommand([]string{"save-now"})
+	assert.EqualError(t, err, "cannot persist")
+	assert.Equal(t, []string{"before, saved: false", "after, saved: true"}, calls)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/lzambarda/hbt/internal"
+)
+
+// session holds what the server knows about a shell session, on top of what
+// the graph keeps.
+type session struct {
+	lastActivity time.Time
+	// Commands are not tracked, hints still work.
+	incognito bool
+	// Whether an idle incognito session already had its graph state ended.
+	expired bool
+}
+
+// now is replaced in tests.
+var now = time.Now
+
+// session returns the session with the given id, creating it if needed.
+// s.mu must be held.
+func (s *Server) session(id string) *session {
+	ss, ok := s.sessions[id]
+	if !ok {
+		ss = &session{}
+		s.sessions[id] = ss
+	}
+	return ss
+}
+
+// touch records activity for session id, returning whether it is incognito.
+func (s *Server) touch(id string) (incognito bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.session(id)
+	ss.lastActivity = now()
+	ss.expired = false
+	return ss.incognito
+}
+
+// setIncognito stops tracking the commands of session id until it ends.
+func (s *Server) setIncognito(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.session(id)
+	ss.lastActivity = now()
+	ss.expired = false
+	ss.incognito = true
+}
+
+// endSession forgets session id.
+func (s *Server) endSession(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// expireSessions ends the sessions without activity for longer than ttl, as
+// shells which are killed never send "end". It returns the ids of the ended
+// sessions.
+// Incognito sessions only lose their graph state, once, and are kept until
+// they end, so that they do not start being tracked if they were just idle.
+func (s *Server) expireSessions(ttl time.Duration) []string {
+	s.mu.Lock()
+	var expired []string
+	for id, ss := range s.sessions {
+		idle := now().Sub(ss.lastActivity)
+		switch {
+		case idle <= ttl:
+		case !ss.incognito:
+			expired = append(expired, id)
+			delete(s.sessions, id)
+		case !ss.expired:
+			expired = append(expired, id)
+			ss.expired = true
+		}
+	}
+	s.mu.Unlock()
+	for _, id := range expired {
+		s.g.End(id)
+	}
+	return expired
+}
+
+func (s *Server) expireRoutine() {
+	if internal.SessionTTL <= 0 {
+		return
+	}
+	// Check often enough for sessions not to outlive the TTL by much.
+	interval := internal.SessionTTL / 10
+	if interval > time.Minute*10 {
+		interval = time.Minute * 10
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			s.swap.RLock()
+			expired := s.expireSessions(internal.SessionTTL)
+			s.swap.RUnlock()
+			if len(expired) > 0 {
+				slog.Debug("Expired sessions", "sessions", expired)
+			}
+		}
+	}()
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lzambarda/hbt/graph"
+)
+
+// DefaultStatsTop is how many commands and directories stats lists by default.
+const DefaultStatsTop = 5
+
+// Stats describes what the server knows.
+type Stats struct {
+	graph.Stats
+	// Size of the cache on disk, 0 if it has not been saved yet.
+	CacheSize int64
+	Sessions  int
+}
+
+// Stats describes the graph, listing at most top commands and directories,
+// along with the cache and the sessions.
+func (s *Server) Stats(top int) (Stats, error) {
+	if top < 0 {
+		return Stats{}, fmt.Errorf("invalid number of entries: %d", top)
+	}
+	st, ok := s.g.(Statter)
+	if !ok {
+		return Stats{}, fmt.Errorf("graph %T cannot describe itself", s.g)
+	}
+	stats := Stats{
+		Stats:    st.Stats(top),
+		Sessions: len(s.Sessions()),
+	}
+	if s.cachePath != "" {
+		info, err := os.Stat(s.cachePath)
+		if err != nil && !os.IsNotExist(err) {
+			return Stats{}, err
+		}
+		if err == nil {
+			stats.CacheSize = info.Size()
+		}
+	}
+	return stats, nil
+}
+
+func (s Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "directories: %d\n", s.Directories)
+	fmt.Fprintf(&b, "commands: %d\n", s.Commands)
+	fmt.Fprintf(&b, "hits: %d\n", s.Hits)
+	fmt.Fprintf(&b, "cache size: %s\n", formatSize(s.CacheSize))
+	fmt.Fprintf(&b, "sessions: %d\n", s.Sessions)
+	b.WriteString("top commands:")
+	for _, c := range s.TopCommands {
+		fmt.Fprintf(&b, "\n  %d\t%s", c.Hits, c.Cmd)
+	}
+	b.WriteString("\nmost active directories:")
+	for _, d := range s.TopDirectories {
+		fmt.Fprintf(&b, "\n  %d\t%s (%d commands)", d.Hits, d.Wd, d.Commands)
+		for _, c := range d.Top {
+			fmt.Fprintf(&b, "\n    %d\t%s", c.Hits, c.Cmd)
+		}
+	}
+	return b.String()
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,76 @@
+//go:build integration && linux
+// +build integration,linux
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBash(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir := t.TempDir()
+	binary, port := startServer(t, dir)
+
+	var rc strings.Builder
+	require.NoError(t, Write(&rc, "bash", Params{Binary: binary, Port: port, CachePath: dir, RuntimeDir: dir}))
+	rc.WriteString("PS1='" + prompt + "'\n")
+	rcPath := filepath.Join(dir, "bashrc")
+	require.NoError(t, os.WriteFile(rcPath, []byte(rc.String()), 0o600))
+
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TERM=dumb",
+		"HISTFILE=" + filepath.Join(dir, "history"),
+		"HISTCONTROL=ignorespace",
+	}
+	term := startTerminal(t, dir, env, "bash", "--noprofile", "--rcfile", rcPath, "-i")
+	term.expect(prompt)
+	session := fmt.Sprintf("%d-", term.cmd.Process.Pid)
+	sub := filepath.Join(dir, "sub")
+
+	t.Run("Track", func(t *testing.T) {
+		term.run("mkdir sub && cd sub")
+		term.run("echo one")
+		term.run("echo one")
+		term.run("echo two")
+		term.run(" echo secret")
+		term.run("")
+		assert.Equal(t, "mkdir sub && cd sub", send(t, port, "hint", "probe-dir", dir), "tracked where it was run")
+		assert.Equal(t, "echo one", send(t, port, "hint", "probe", sub))
+		assert.Equal(t, "echo two", send(t, port, "hint", "probe", sub))
+		assert.Equal(t, "echo one", send(t, port, "hint", "probe", sub), "secret and empty line not tracked")
+		assert.Contains(t, send(t, port, "sessions"), session)
+	})
+
+	t.Run("Hint", func(t *testing.T) {
+		term.widget("\x18h")
+		out := term.run("")
+		assert.Contains(t, out, "\none\r\n", "hint inserted and run")
+		assert.Contains(t, send(t, port, "stats", "1"), "top commands:\n  3\techo one\n")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		term.widget("\x18h")
+		term.widget("\x18d")
+		term.run("")
+		assert.Equal(t, "echo two", send(t, port, "hint", "probe2", sub))
+	})
+
+	t.Run("Exit", func(t *testing.T) {
+		term.send("exit\n")
+		require.NoError(t, term.cmd.Wait())
+		assert.NotContains(t, send(t, port, "sessions"), session)
+	})
+}
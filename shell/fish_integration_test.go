@@ -0,0 +1,83 @@
+//go:build integration && linux
+// +build integration,linux
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFish(t *testing.T) {
+	if _, err := exec.LookPath("fish"); err != nil {
+		t.Skip("fish is not installed")
+	}
+	dir := t.TempDir()
+	binary, port := startServer(t, dir)
+
+	// fish reads its configuration from XDG_CONFIG_HOME.
+	var rc strings.Builder
+	require.NoError(t, Write(&rc, "fish", Params{Binary: binary, Port: port, CachePath: dir, RuntimeDir: dir}))
+	rc.WriteString("function fish_prompt; echo -n '" + prompt + "'; end\n")
+	rc.WriteString("function fish_greeting; end\n")
+	configDir := filepath.Join(dir, "config", "fish")
+	require.NoError(t, os.MkdirAll(configDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.fish"), []byte(rc.String()), 0o600))
+
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TERM=dumb",
+		"XDG_CONFIG_HOME=" + filepath.Join(dir, "config"),
+		"XDG_DATA_HOME=" + filepath.Join(dir, "data"),
+	}
+	term := startTerminal(t, dir, env, "fish", "-i")
+	term.expect(prompt)
+	session := fmt.Sprintf("%d-", term.cmd.Process.Pid)
+	sub := filepath.Join(dir, "sub")
+
+	t.Run("Track", func(t *testing.T) {
+		term.run("mkdir sub; and cd sub")
+		term.run("echo one")
+		term.run("echo one")
+		term.run("echo two")
+		term.run("no-such-command-hbt")
+		assert.Equal(t, "mkdir sub; and cd sub", send(t, port, "hint", "probe-dir", dir), "tracked where it was run")
+		assert.Equal(t, "echo one", send(t, port, "hint", "probe", sub))
+		assert.Equal(t, "echo two", send(t, port, "hint", "probe", sub))
+		assert.Equal(t, "echo one", send(t, port, "hint", "probe", sub), "commands not found are not tracked")
+		assert.Contains(t, send(t, port, "sessions"), session)
+	})
+
+	t.Run("Hint", func(t *testing.T) {
+		term.send("\t")
+		term.send("\r")
+		term.expect("\none\r\n")
+		term.expect(prompt)
+		assert.Eventually(t, func() bool {
+			return strings.Contains(send(t, port, "stats", "1"), "top commands:\n  3\techo one\n")
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		term.send("\t")
+		term.send("\x1b[3~")
+		assert.Eventually(t, func() bool {
+			return !strings.Contains(send(t, port, "explain", "probe", sub), "echo one")
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("Exit", func(t *testing.T) {
+		term.send("\x15exit\r")
+		require.NoError(t, term.cmd.Wait())
+		assert.NotContains(t, send(t, port, "sessions"), session)
+	})
+}
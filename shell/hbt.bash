@@ -0,0 +1,94 @@
+# Generated by "hbtsrv init bash", load it from .bashrc with:
+#   eval "$(hbtsrv init bash)"
+
+_hbt_bin={{ quote .Binary }}
+export HBT_PORT={{ quote .Port }}
+{{- if .CachePath }}
+export HBT_CACHE_PATH={{ quote .CachePath }}
+{{- end }}
+{{- if .RuntimeDir }}
+export HBT_RUNTIME_DIR={{ quote .RuntimeDir }}
+{{- end }}
+
+# The server is started in the background by the first request, these
+# functions manage it by hand.
+function hbt_start() {
+	if [ "$1" = "--debug" ]; then
+		"$_hbt_bin" --debug
+	else
+		"$_hbt_bin" daemon start
+	fi
+}
+
+function hbt_stop() { "$_hbt_bin" daemon stop ; }
+
+# Identify this shell with its PID and, unless HBT_SESSION_NONCE=0, its start
+# time so that a recycled PID does not inherit the session of a dead shell.
+if [ "${HBT_SESSION_NONCE:-1}" = "1" ]; then
+	_hbt_session="$$-${EPOCHSECONDS:-$(date +%s)}"
+else
+	_hbt_session="$$"
+fi
+
+# Stop learning from the current shell, hints keep working until it exits.
+function hbt_incognito() { "$_hbt_bin" client incognito "$_hbt_session" ; }
+
+# Explain how the next hint for the current directory is chosen.
+function hbt_explain() { "$_hbt_bin" client explain "$_hbt_session" "$PWD" ; }
+
+function _hbt_end_session() { "$_hbt_bin" client end "$_hbt_session" 2>/dev/null ; }
+trap _hbt_end_session EXIT
+
+# Bash has no preexec hook, so commands are tracked once they are done, when
+# the prompt is about to be shown. They are read from the history, which means
+# that commands left out of it (see HISTCONTROL and HISTIGNORE) are not tracked.
+# The directory is the one of the previous prompt, as the command might have
+# changed it.
+function _hbt_track() {
+	local entry
+	entry=$(HISTTIMEFORMAT= builtin history 1)
+	if [[ $entry =~ ^[[:space:]]*([0-9]+)[*]?[[:space:]]+(.*)$ ]]; then
+		# The first prompt must not track the last command of the history file.
+		if [ -n "$_hbt_history" ] && [ "${BASH_REMATCH[1]}" != "$_hbt_history" ]; then
+			"$_hbt_bin" client track "$_hbt_session" "$_hbt_wd" "${BASH_REMATCH[2]}" 2>/dev/null
+		fi
+		_hbt_history=${BASH_REMATCH[1]}
+	else
+		_hbt_history=0
+	fi
+	_hbt_wd=$PWD
+}
+if [[ "$(declare -p PROMPT_COMMAND 2>/dev/null)" == "declare -a"* ]]; then
+	PROMPT_COMMAND=(_hbt_track "${PROMPT_COMMAND[@]}")
+else
+	PROMPT_COMMAND="_hbt_track${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+
+# With an empty line, or the previous hint, replace it with the next hint.
+function _hbt_hint() {
+	if [ -n "$READLINE_LINE" ] && [ "$READLINE_LINE" != "$_hbt_last_hint" ]; then
+		return
+	fi
+	local hint
+	hint=$("$_hbt_bin" client hint "$_hbt_session" "$PWD" 2>/dev/null)
+	if [ -z "$hint" ] || [ "$hint" = '¯\_(ツ)_/¯' ]; then
+		return
+	fi
+	_hbt_last_hint=$hint
+	READLINE_LINE=$hint
+	READLINE_POINT=${#hint}
+}
+
+# Forget the hint being shown.
+function _hbt_delsuggestion() {
+	if [ -n "$READLINE_LINE" ] && [ "$READLINE_LINE" = "$_hbt_last_hint" ]; then
+		"$_hbt_bin" client del "$_hbt_session" "$PWD" "$READLINE_LINE" 2>/dev/null
+		READLINE_LINE=
+		READLINE_POINT=0
+	fi
+}
+
+# Bash cannot fall back to completion from a widget, so hints are not bound to
+# TAB like in zsh.
+bind -x "\"${HBT_HINT_KEY:-\C-xh}\": _hbt_hint"
+bind -x "\"${HBT_DELETE_KEY:-\C-xd}\": _hbt_delsuggestion"
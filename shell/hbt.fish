@@ -0,0 +1,95 @@
+# Generated by "hbtsrv init fish", load it from config.fish with:
+#   hbtsrv init fish | source
+
+set -g _hbt_bin {{ quote .Binary }}
+set -gx HBT_PORT {{ quote .Port }}
+{{- if .CachePath }}
+set -gx HBT_CACHE_PATH {{ quote .CachePath }}
+{{- end }}
+{{- if .RuntimeDir }}
+set -gx HBT_RUNTIME_DIR {{ quote .RuntimeDir }}
+{{- end }}
+
+# The server is started in the background by the first request, these
+# functions manage it by hand.
+function hbt_start
+	if test "$argv[1]" = --debug
+		$_hbt_bin --debug
+	else
+		$_hbt_bin daemon start
+	end
+end
+
+function hbt_stop
+	$_hbt_bin daemon stop
+end
+
+# Identify this shell with its PID and, unless HBT_SESSION_NONCE=0, its start
+# time so that a recycled PID does not inherit the session of a dead shell.
+if test "$HBT_SESSION_NONCE" = 0
+	set -g _hbt_session $fish_pid
+else
+	set -g _hbt_session $fish_pid-(date +%s)
+end
+
+# Stop learning from the current shell, hints keep working until it exits.
+function hbt_incognito
+	$_hbt_bin client incognito $_hbt_session
+end
+
+# Explain how the next hint for the current directory is chosen.
+function hbt_explain
+	$_hbt_bin client explain $_hbt_session $PWD
+end
+
+function _hbt_end_session --on-event fish_exit
+	$_hbt_bin client end $_hbt_session 2>/dev/null
+end
+
+# Remember where the command runs, as it might change directory.
+function _hbt_preexec --on-event fish_preexec
+	set -g _hbt_wd $PWD
+end
+
+# Track the command once it is done, unless it was not found: typos are not
+# worth suggesting.
+function _hbt_postexec --on-event fish_postexec
+	set -l last_status $status
+	if test -z "$argv[1]"; or test $last_status -eq 127
+		return
+	end
+	$_hbt_bin client track $_hbt_session $_hbt_wd $argv[1] 2>/dev/null
+end
+
+# With an empty line, or the previous hint, replace it with the next hint,
+# otherwise complete.
+function _hbt_hint
+	set -l line (commandline | string collect)
+	if test -n "$line"; and test "$line" != "$_hbt_last_hint"
+		commandline -f complete
+		return
+	end
+	set -l hint ($_hbt_bin client hint $_hbt_session $PWD 2>/dev/null | string collect)
+	if test -z "$hint"; or test "$hint" = '¯\_(ツ)_/¯'
+		return
+	end
+	set -g _hbt_last_hint $hint
+	commandline -r -- $hint
+	commandline -C (string length -- $hint)
+end
+
+# Forget the hint being shown, otherwise delete a character.
+function _hbt_delsuggestion
+	set -l line (commandline | string collect)
+	if test -n "$line"; and test "$line" = "$_hbt_last_hint"
+		$_hbt_bin client del $_hbt_session $PWD $line 2>/dev/null
+		commandline -r ''
+	else
+		commandline -f delete-char
+	end
+end
+
+for mode in default insert
+	bind -M $mode \t _hbt_hint
+	bind -M $mode \e\[3~ _hbt_delsuggestion
+end
@@ -0,0 +1,181 @@
+//go:build integration && linux
+// +build integration,linux
+
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/lzambarda/hbt/client"
+	"github.com/stretchr/testify/require"
+)
+
+// prompt is set as PS1 so that tests know when a command is done.
+const prompt = "[hbt-ready]$ "
+
+// terminal is an interactive shell running in a pseudo terminal.
+type terminal struct {
+	t      *testing.T
+	cmd    *exec.Cmd
+	master *os.File
+	mu     sync.Mutex
+	out    bytes.Buffer
+	// Offset of out up to which expect already matched.
+	seen int
+}
+
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err = ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close() //nolint:errcheck,gosec // It is okay.
+		return nil, nil, err
+	}
+	var n uint32
+	if err = ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close() //nolint:errcheck,gosec // It is okay.
+		return nil, nil, err
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close() //nolint:errcheck,gosec // It is okay.
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// startTerminal runs name with args in a pseudo terminal, from dir.
+func startTerminal(t *testing.T, dir string, env []string, name string, args ...string) *terminal {
+	t.Helper()
+	master, slave, err := openPty()
+	require.NoError(t, err)
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	require.NoError(t, cmd.Start())
+	slave.Close() //nolint:errcheck,gosec // It is okay.
+	term := &terminal{t: t, cmd: cmd, master: master}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := master.Read(buf)
+			term.mu.Lock()
+			term.out.Write(buf[:n])
+			term.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill() //nolint:errcheck,gosec // It is okay.
+		cmd.Wait()         //nolint:errcheck,gosec // It is okay.
+		master.Close()     //nolint:errcheck,gosec // It is okay.
+	})
+	return term
+}
+
+func (term *terminal) send(s string) {
+	term.t.Helper()
+	_, err := term.master.Write([]byte(s))
+	require.NoError(term.t, err)
+}
+
+// expect waits for s to be printed after what previous calls matched, and
+// returns everything printed up to it.
+func (term *terminal) expect(s string) string {
+	term.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		term.mu.Lock()
+		out := term.out.String()[term.seen:]
+		if i := strings.Index(out, s); i >= 0 {
+			term.seen += i + len(s)
+			term.mu.Unlock()
+			return out[:i]
+		}
+		term.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	require.FailNowf(term.t, "timeout", "%q not printed, got %q", s, term.out.String()[term.seen:])
+	return ""
+}
+
+// run types line and waits for the next prompt, returning the output.
+func (term *terminal) run(line string) string {
+	term.t.Helper()
+	term.send(line + "\n")
+	return term.expect(prompt)
+}
+
+// widget types the key sequence of a widget, which redraws the prompt.
+func (term *terminal) widget(seq string) {
+	term.t.Helper()
+	term.send(seq)
+	term.expect(prompt)
+}
+
+// startServer builds hbt and runs it on a free port, returning the path of the
+// binary and the port.
+func startServer(t *testing.T, dir string) (binary, port string) {
+	t.Helper()
+	binary = filepath.Join(dir, "hbtsrv")
+	build := exec.Command("go", "build", "-o", binary, "github.com/lzambarda/hbt")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err = net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	srv := exec.Command(binary, "--port", port, "--cache", dir, "--runtime-dir", dir)
+	require.NoError(t, srv.Start())
+	t.Cleanup(func() {
+		client.Send(port, "shutdown") //nolint:errcheck,gosec // It is okay.
+		srv.Wait()                    //nolint:errcheck,gosec // It is okay.
+	})
+	for i := 0; ; i++ {
+		_, err = client.Send(port, "sessions")
+		if err == nil || !errors.Is(err, client.ErrNoServer) || i == 100 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	return binary, port
+}
+
+func send(t *testing.T, port string, args ...string) string {
+	t.Helper()
+	result, err := client.Send(port, args...)
+	require.NoError(t, err)
+	return result
+}
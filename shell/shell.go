@@ -0,0 +1,74 @@
+// Package shell generates the scripts integrating hbt in shells.
+package shell
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//go:embed hbt.*
+var scripts embed.FS
+
+// ErrUnsupported is returned when asking for the script of an unknown shell.
+var ErrUnsupported = errors.New("unsupported shell")
+
+// Params are the values the scripts are generated with.
+type Params struct {
+	// Absolute path of the hbt binary.
+	Binary string
+	// Port of the server.
+	Port string
+	// Directory of the cache, left to the default if empty.
+	CachePath string
+	// Directory of the pid file, left to the default if empty.
+	RuntimeDir string
+}
+
+// Shells returns the names of the supported shells.
+func Shells() []string {
+	entries, err := scripts.ReadDir(".")
+	if err != nil {
+		return nil
+	}
+	shells := make([]string, 0, len(entries))
+	for _, e := range entries {
+		shells = append(shells, strings.TrimPrefix(e.Name(), "hbt."))
+	}
+	sort.Strings(shells)
+	return shells
+}
+
+// Write writes the script of the given shell to w.
+func Write(w io.Writer, shell string, p Params) error {
+	b, err := scripts.ReadFile("hbt." + shell)
+	if err != nil {
+		return fmt.Errorf("%q: %w, expected one of %s", shell, ErrUnsupported, strings.Join(Shells(), ", "))
+	}
+	q := quote
+	if shell == "fish" {
+		q = quoteFish
+	}
+	t, err := template.New(shell).Funcs(template.FuncMap{"quote": q}).Parse(string(b))
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, p)
+}
+
+// quote returns s as a single quoted string, which POSIX shells and zsh do
+// not expand.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteFish is like quote, for fish, where backslashes are special within
+// single quotes too.
+func quoteFish(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
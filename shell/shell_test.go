@@ -0,0 +1,57 @@
+package shell
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShell(t *testing.T) {
+	t.Run("Write", testShellWrite)
+	t.Run("Quote", testShellQuote)
+}
+
+func testShellWrite(t *testing.T) {
+	p := Params{
+		Binary:     "/opt/hbt/hbtsrv",
+		Port:       "4242",
+		CachePath:  "/home/me/.cache/hbt",
+		RuntimeDir: "/run/hbt",
+	}
+	for _, sh := range Shells() {
+		var b strings.Builder
+		require.NoError(t, Write(&b, sh, p), sh)
+		assert.Contains(t, b.String(), "'/opt/hbt/hbtsrv'", sh)
+		assert.Contains(t, b.String(), "'4242'", sh)
+		assert.Contains(t, b.String(), "'/home/me/.cache/hbt'", sh)
+		assert.Contains(t, b.String(), "'/run/hbt'", sh)
+		assert.NotContains(t, b.String(), "{{", sh)
+		if _, err := exec.LookPath(sh); err == nil {
+			check := exec.Command(sh, "-n")
+			check.Stdin = strings.NewReader(b.String())
+			out, err := check.CombinedOutput()
+			assert.NoError(t, err, "%s: %s", sh, out)
+		}
+	}
+	assert.Subset(t, Shells(), []string{"bash", "fish", "zsh"})
+
+	for _, sh := range Shells() {
+		var b strings.Builder
+		require.NoError(t, Write(&b, sh, Params{Binary: "hbtsrv", Port: "4242"}), sh)
+		assert.NotContains(t, b.String(), "HBT_CACHE_PATH", "%s: the default follows the environment", sh)
+		assert.NotContains(t, b.String(), "HBT_RUNTIME_DIR", sh)
+	}
+
+	err := Write(&strings.Builder{}, "ksh", p)
+	assert.True(t, errors.Is(err, ErrUnsupported))
+}
+
+func testShellQuote(t *testing.T) {
+	assert.Equal(t, "'/a b/$HOME'", quote("/a b/$HOME"))
+	assert.Equal(t, `'it'\''s'`, quote("it's"))
+	assert.Equal(t, `'it\'s \\o/'`, quoteFish(`it's \o/`))
+}
@@ -0,0 +1,276 @@
+// Package syncdir shares what hbt learns between hosts through a directory
+// synchronised by an external tool (e.g. Dropbox or Syncthing).
+//
+// Each host only ever writes to its own subdirectory, where it periodically
+// exports the commands it tracked as delta files. Hosts then import the
+// deltas of the others they have not seen yet, so that no file is ever written
+// by two hosts and there is nothing to conflict on.
+package syncdir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lzambarda/hbt/cache"
+)
+
+// Graph has all the functions a graph needs to be synchronised.
+type Graph interface {
+	// RecordDelta starts remembering what is tracked, for ExportDelta.
+	RecordDelta()
+	// ExportDelta saves what has been tracked since the previous export to
+	// filePath, returning false if there was nothing to save.
+	ExportDelta(filePath string) (bool, error)
+	// ImportDelta adds the content of a delta exported by another host.
+	ImportDelta(filePath string) error
+}
+
+const (
+	deltaExt = ".hbtdelta"
+	// Own deltas older than this are deleted, hosts which have not synced for
+	// longer will miss them.
+	deltaRetention = 30 * 24 * time.Hour
+	// A delta which still cannot be imported after this many syncs is
+	// skipped, so that it does not hold back the following ones.
+	importAttempts = 10
+)
+
+// Syncer exports and imports the deltas of a graph.
+type Syncer struct {
+	g         Graph
+	dir       string
+	host      string
+	statePath string
+	// host -> name of the last delta imported from it.
+	imported map[string]string
+	// path -> how many times a delta failed to be imported.
+	failures map[string]int
+	// Whether imported changed since it was last persisted.
+	changed bool
+	mu      sync.Mutex
+	// Serialises Sync and Export.
+	syncing sync.Mutex
+}
+
+// New returns a Syncer for graph g, sharing deltas in dir under the given host
+// name. statePath is a local file used to remember which deltas have already
+// been imported, it must not be inside dir. It is only written by Checkpoint.
+func New(g Graph, dir, host, statePath string) (*Syncer, error) {
+	host = sanitise(host)
+	if host == "" {
+		return nil, errors.New("invalid sync host name")
+	}
+	s := &Syncer{
+		g:         g,
+		dir:       dir,
+		host:      host,
+		statePath: statePath,
+		imported:  map[string]string{},
+		failures:  map[string]int{},
+	}
+	b, err := cache.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(b, &s.imported); err != nil {
+			return nil, fmt.Errorf("%s: %w", statePath, err)
+		}
+	}
+	if err = os.MkdirAll(filepath.Join(dir, host), 0o700); err != nil {
+		return nil, err
+	}
+	g.RecordDelta()
+	return s, nil
+}
+
+// sanitise makes sure host can be used as a directory name.
+func sanitise(host string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, host), ".")
+}
+
+// Start periodically syncs in the background, interval must be positive.
+func (s *Syncer) Start(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid sync interval %s, it must be positive", interval)
+	}
+	go func() {
+		for {
+			if err := s.Sync(); err != nil {
+				slog.Error("Cannot sync", "dir", s.dir, "error", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+	return nil
+}
+
+// Checkpoint must be called right before the graph is saved. The returned
+// function persists which deltas have been imported until then, it must be
+// called once the graph, which holds them, is saved. Otherwise a crash would
+// lose the imported hits without importing them again.
+func (s *Syncer) Checkpoint() (saved func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.changed {
+		return nil
+	}
+	s.changed = false
+	b, err := json.Marshal(s.imported)
+	return func() error {
+		if err == nil {
+			err = cache.WriteAtomic(s.statePath, b)
+		}
+		if err != nil {
+			s.mu.Lock()
+			s.changed = true
+			s.mu.Unlock()
+		}
+		return err
+	}
+}
+
+// Sync exports the local delta, then imports the deltas of the other hosts.
+func (s *Syncer) Sync() error {
+	s.syncing.Lock()
+	defer s.syncing.Unlock()
+	if err := s.export(); err != nil {
+		return err
+	}
+	return s.importAll()
+}
+
+// Export only exports the local delta, e.g. right before stopping, so that
+// what was tracked since the last sync is not left behind.
+func (s *Syncer) Export() error {
+	s.syncing.Lock()
+	defer s.syncing.Unlock()
+	return s.export()
+}
+
+func (s *Syncer) export() error {
+	own := filepath.Join(s.dir, s.host)
+	// Names are zero padded so that their lexical order is chronological.
+	name := fmt.Sprintf("%020d%s", time.Now().UnixNano(), deltaExt)
+	exported, err := s.g.ExportDelta(filepath.Join(own, name))
+	if err != nil {
+		return err
+	}
+	if exported {
+		slog.Debug("Exported delta", "name", name)
+	}
+	deltas, err := listDeltas(own)
+	if err != nil {
+		return err
+	}
+	for _, d := range deltas {
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < deltaRetention {
+			continue
+		}
+		if err = os.Remove(filepath.Join(own, d.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Syncer) importAll() error {
+	hosts, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		if !h.IsDir() || h.Name() == s.host {
+			continue
+		}
+		deltas, err := listDeltas(filepath.Join(s.dir, h.Name()))
+		if err != nil {
+			return err
+		}
+		for _, d := range deltas {
+			s.mu.Lock()
+			last := s.imported[h.Name()]
+			s.mu.Unlock()
+			if d.Name() <= last {
+				continue
+			}
+			filePath := filepath.Join(s.dir, h.Name(), d.Name())
+			if err = s.g.ImportDelta(filePath); err != nil {
+				if errors.Is(err, cache.ErrNoKey) || errors.Is(err, cache.ErrWrongKey) {
+					// It is imported once the keys match, however long it
+					// takes.
+					slog.Error("Cannot import delta, every host sharing the sync directory must use the same cache key", "path", filePath, "error", err)
+					break
+				}
+				s.failures[filePath]++
+				if s.failures[filePath] < importAttempts {
+					// It might still be being synchronised, try again later.
+					slog.Warn("Cannot import delta", "path", filePath, "error", err)
+					break
+				}
+				slog.Error("Skipping delta which cannot be imported", "path", filePath, "attempts", importAttempts, "error", err)
+			} else {
+				slog.Debug("Imported delta", "path", filePath)
+			}
+			delete(s.failures, filePath)
+			s.mu.Lock()
+			s.imported[h.Name()] = d.Name()
+			s.changed = true
+			s.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// OwnDeltas returns the paths of the deltas exported to dir by host, oldest
+// first. No other host writes them, so they can be rewritten, e.g. to forget
+// commands.
+func OwnDeltas(dir, host string) ([]string, error) {
+	own := filepath.Join(dir, sanitise(host))
+	deltas, err := listDeltas(own)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	paths := make([]string, 0, len(deltas))
+	for _, d := range deltas {
+		paths = append(paths, filepath.Join(own, d.Name()))
+	}
+	return paths, nil
+}
+
+// listDeltas returns the deltas in dir, oldest first.
+func listDeltas(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	deltas := entries[:0]
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), deltaExt) {
+			deltas = append(deltas, e)
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].Name() < deltas[j].Name()
+	})
+	return deltas, nil
+}
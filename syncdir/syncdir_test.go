@@ -0,0 +1,113 @@
+package syncdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lzambarda/hbt/cache"
+	"github.com/lzambarda/hbt/graph/naive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncdir(t *testing.T) {
+	shared := t.TempDir()
+	newHost := func(name string) (*naive.Graph, *Syncer) {
+		g := naive.NewGraph(10, 3)
+		s, err := New(g, shared, name, filepath.Join(t.TempDir(), "state"))
+		require.NoError(t, err)
+		return g, s
+	}
+	a, syncA := newHost("laptop")
+	b, syncB := newHost("dev/box")
+
+	a.Track("1", "/repo", "make")
+	a.Track("1", "/repo", "make")
+	require.NoError(t, syncA.Sync())
+	require.NoError(t, syncB.Sync())
+	assert.Equal(t, "make", b.Hint("1", "/repo"))
+
+	b.Track("2", "/repo", "go test")
+	b.Track("2", "/repo", "go test")
+	b.Track("2", "/repo", "go test")
+	require.NoError(t, syncB.Sync())
+	require.NoError(t, syncA.Sync())
+	assert.Equal(t, "go test", a.Hint("1", "/repo"), "3 hits from b against 2 local ones")
+
+	// Syncing again must not import anything twice, nor export imported hits.
+	require.NoError(t, syncA.Sync())
+	require.NoError(t, syncB.Sync())
+	assert.Equal(t, "go test", b.Hint("3", "/repo"))
+	assert.Equal(t, "make", b.Hint("3", "/repo"))
+	assert.Equal(t, "go test", a.Hint("3", "/repo"))
+	assert.Equal(t, "make", a.Hint("3", "/repo"))
+
+	// A host restarted before saving its graph imports everything again.
+	restarted, err := New(naive.NewGraph(10, 3), shared, "dev/box", syncB.statePath)
+	require.NoError(t, err)
+	assert.Empty(t, restarted.imported)
+
+	// Once saved, it remembers what it already imported.
+	saved := syncB.Checkpoint()
+	require.NotNil(t, saved)
+	require.NoError(t, saved())
+	assert.Nil(t, syncB.Checkpoint(), "nothing new to persist")
+	restarted, err = New(naive.NewGraph(10, 3), shared, "dev/box", syncB.statePath)
+	require.NoError(t, err)
+	assert.Equal(t, syncB.imported, restarted.imported)
+
+	assert.Error(t, syncA.Start(0))
+
+	// What cannot be exported is exported by the next sync.
+	own := filepath.Join(shared, "laptop")
+	require.NoError(t, os.RemoveAll(own))
+	require.NoError(t, os.WriteFile(own, nil, 0o600))
+	a.Track("1", "/tmp", "ls")
+	assert.Error(t, syncA.Sync())
+	require.NoError(t, os.Remove(own))
+	require.NoError(t, os.Mkdir(own, 0o700))
+	require.NoError(t, syncA.Export())
+	require.NoError(t, syncB.Sync())
+	assert.Equal(t, "ls", b.Hint("4", "/tmp"))
+
+	// A delta which cannot be imported only holds back the following ones for
+	// a while.
+	require.NoError(t, os.WriteFile(filepath.Join(own, fmt.Sprintf("%020d%s", time.Now().UnixNano(), deltaExt)), []byte("garbage"), 0o600))
+	a.Track("1", "/srv", "top")
+	require.NoError(t, syncA.Export())
+	require.NoError(t, syncB.Sync())
+	assert.NotEqual(t, "top", b.Hint("5", "/srv"))
+	for i := 1; i < importAttempts; i++ {
+		require.NoError(t, syncB.Sync())
+	}
+	assert.Equal(t, "top", b.Hint("5", "/srv"))
+}
+
+func TestSyncdirKey(t *testing.T) {
+	defer cache.SetKey("") //nolint:errcheck // It is okay.
+	shared := t.TempDir()
+	a := naive.NewGraph(10, 3)
+	syncA, err := New(a, shared, "laptop", filepath.Join(t.TempDir(), "state"))
+	require.NoError(t, err)
+	b := naive.NewGraph(10, 3)
+	syncB, err := New(b, shared, "desktop", filepath.Join(t.TempDir(), "state"))
+	require.NoError(t, err)
+
+	require.NoError(t, cache.SetKey("laptop key"))
+	a.Track("1", "/repo", "make")
+	require.NoError(t, syncA.Sync())
+
+	// The deltas of a host using another key are never skipped, they are
+	// imported once the keys match.
+	require.NoError(t, cache.SetKey("desktop key"))
+	for i := 0; i <= importAttempts; i++ {
+		require.NoError(t, syncB.Sync())
+	}
+	assert.NotEqual(t, "make", b.Hint("1", "/repo"))
+	require.NoError(t, cache.SetKey("laptop key"))
+	require.NoError(t, syncB.Sync())
+	assert.Equal(t, "make", b.Hint("1", "/repo"))
+}
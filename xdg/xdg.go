@@ -0,0 +1,49 @@
+// Package xdg locates the directories of hbt according to the XDG base
+// directory specification.
+package xdg
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const name = "hbt"
+
+// ConfigDir returns $XDG_CONFIG_HOME/hbt, ~/.config/hbt by default.
+func ConfigDir() string {
+	return dir("XDG_CONFIG_HOME", ".config")
+}
+
+// DataDir returns $XDG_DATA_HOME/hbt, ~/.local/share/hbt by default.
+func DataDir() string {
+	return dir("XDG_DATA_HOME", ".local", "share")
+}
+
+// StateDir returns $XDG_STATE_HOME/hbt, ~/.local/state/hbt by default.
+func StateDir() string {
+	return dir("XDG_STATE_HOME", ".local", "state")
+}
+
+// RuntimeDir returns $XDG_RUNTIME_DIR/hbt. The specification has no default
+// for it, so StateDir is used when it is not set.
+func RuntimeDir() string {
+	if base := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(base) {
+		return filepath.Join(base, name)
+	}
+	return StateDir()
+}
+
+// dir returns the hbt directory in the base directory set by the env
+// variable, or in the home directory joined with fallback. Relative paths are
+// invalid and ignored, as the specification requires. If the home directory
+// is unknown too, the current directory is used.
+func dir(env string, fallback ...string) string {
+	if base := os.Getenv(env); filepath.IsAbs(base) {
+		return filepath.Join(base, name)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(append(append([]string{home}, fallback...), name)...)
+}
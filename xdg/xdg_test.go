@@ -0,0 +1,27 @@
+package xdg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXDG(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	for _, env := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_RUNTIME_DIR"} {
+		t.Setenv(env, "")
+	}
+	assert.Equal(t, "/home/me/.config/hbt", ConfigDir())
+	assert.Equal(t, "/home/me/.local/share/hbt", DataDir())
+	assert.Equal(t, "/home/me/.local/state/hbt", StateDir())
+	assert.Equal(t, "/home/me/.local/state/hbt", RuntimeDir())
+
+	t.Setenv("XDG_CONFIG_HOME", "/config")
+	t.Setenv("XDG_DATA_HOME", "/data")
+	t.Setenv("XDG_STATE_HOME", "relative")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	assert.Equal(t, "/config/hbt", ConfigDir())
+	assert.Equal(t, "/data/hbt", DataDir())
+	assert.Equal(t, "/home/me/.local/state/hbt", StateDir(), "relative paths are ignored")
+	assert.Equal(t, "/run/user/1000/hbt", RuntimeDir())
+}